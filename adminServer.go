@@ -0,0 +1,198 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// every server of the domain, as seen by the domain runtime MBean server
+	adminServerRuntimeBean = "com.bea:Type=ServerRuntime,*"
+	serverStateRunning     = "RUNNING"
+)
+
+var adminServerRuntimeAttributes = []string{"Name", "ListenAddress", "ListenPort", "State", "AdminServer"}
+
+// A server of a WebLogic domain from its ServerRuntime bean
+type managedServer struct {
+	Name          string
+	ListenAddress string
+	ListenPort    string
+	State         string
+	AdminServer   bool
+}
+
+// Last managed server list of an admin server
+type adminExpansion struct {
+	servers   []managedServer
+	refreshed time.Time
+}
+
+// Add a target for each running managed server of the AdminServer targets.
+// The server lists are cached and refreshed every AdminExpansionInterval, an
+// admin server that can't be reached keeps its last known servers.
+func (cli *PsoftJmxClient) expandAdminServers(domainList []*PsoftDomain, localHost *localIdentity) []*PsoftDomain {
+	names := make(map[string]bool, len(domainList))
+	admins := make(map[string]bool)
+	for _, domain := range domainList {
+		names[domain.DomainName] = true
+		if domain.AdminServer {
+			admins[domain.DomainName] = true
+		}
+	}
+	// forget admin servers removed from the inventory
+	cli.statsMu.Lock()
+	for name := range cli.admins {
+		if !admins[name] {
+			delete(cli.admins, name)
+		}
+	}
+	cli.statsMu.Unlock()
+	expanded := domainList
+	for _, admin := range domainList {
+		if !admin.AdminServer {
+			continue
+		}
+		for _, server := range cli.managedServers(admin, localHost) {
+			if server.AdminServer || server.Name == admin.ServerName || server.State != serverStateRunning {
+				continue
+			}
+			managed := *admin
+			managed.DomainName = admin.DomainName + "_" + server.Name
+			managed.ServerName = server.Name
+			managed.AdminServer = false
+			managed.source = admin.source + " (managed server " + server.Name + ")"
+			if server.ListenAddress != "" {
+				managed.HostName = server.ListenAddress
+			}
+			if server.ListenPort != "" {
+				managed.JMXPort = server.ListenPort
+			}
+			if names[managed.DomainName] {
+				srvlog.Warn("Managed server " + server.Name + " of " + admin.DomainName + " is already in the inventory as " + managed.DomainName)
+				continue
+			}
+			names[managed.DomainName] = true
+			expanded = append(expanded, &managed)
+		}
+	}
+	return expanded
+}
+
+// Servers of an admin server's domain, from the cache unless it is due for a refresh
+func (cli *PsoftJmxClient) managedServers(admin *PsoftDomain, localHost *localIdentity) []managedServer {
+	now := time.Now()
+	cli.statsMu.Lock()
+	cached := cli.admins[admin.DomainName]
+	cli.statsMu.Unlock()
+	if cached != nil && now.Sub(cached.refreshed) < cli.Config.AdminExpansionInterval {
+		return cached.servers
+	}
+
+	servers, err := cli.queryManagedServers(admin, localHost)
+	if err != nil {
+		// try again at the next refresh
+		if cached != nil {
+			srvlog.Error("Unable to refresh the managed servers of "+admin.DomainName+", keeping the last list: "+err.Error(), "servers", len(cached.servers))
+			servers = cached.servers
+		} else {
+			srvlog.Error("Unable to get the managed servers of " + admin.DomainName + ": " + err.Error())
+		}
+	} else {
+		srvlog.Info("Refreshed the managed servers of "+admin.DomainName, "servers", len(servers))
+	}
+	cli.statsMu.Lock()
+	if cli.admins == nil {
+		cli.admins = make(map[string]*adminExpansion)
+	}
+	cli.admins[admin.DomainName] = &adminExpansion{servers: servers, refreshed: now}
+	cli.statsMu.Unlock()
+	return servers
+}
+
+// Ask the domain runtime MBean server of the admin server for every ServerRuntime
+func (cli *PsoftJmxClient) queryManagedServers(admin *PsoftDomain, localHost *localIdentity) ([]managedServer, error) {
+	target := *admin
+	target.ConnectHost = target.HostName
+	if isLocal, _ := localHost.isLocal(target.HostName); isLocal {
+		target.ConnectHost = cli.Config.LocalConnectAddress
+	}
+	conn := &JMXConnection{
+		NGAddress:  cli.Config.NailgunServerConn,
+		ConnectURL: target.jmxURL(),
+		UserID:     target.JMXUser,
+		Password:   target.JMXPassword,
+		CredFile:   cli.Config.JMXCredentialFile,
+	}
+	queryList := make([]string, 0, len(adminServerRuntimeAttributes))
+	for _, attribute := range adminServerRuntimeAttributes {
+		queryList = append(queryList, adminServerRuntimeBean+"/"+attribute)
+	}
+	rawResponse, err := conn.RunJMXCommand(admin.DomainName, queryList)
+	if err != nil {
+		return nil, err
+	}
+	return parseServerRuntimes(admin.DomainName, rawResponse)
+}
+
+// Group the JMXQuery results by bean into servers, sorted by name
+func parseServerRuntimes(domainName string, rawResponse string) ([]managedServer, error) {
+	var results []JMXQueryResults
+	if err := yaml.Unmarshal([]byte(rawResponse), &results); err != nil {
+		return nil, &JMXError{Domain: domainName, Response: rawResponse, Kind: ErrParse, Err: err}
+	}
+	byBean := make(map[string]*managedServer)
+	for _, result := range results {
+		server, ok := byBean[result.MBeanName]
+		if !ok {
+			server = &managedServer{}
+			byBean[result.MBeanName] = server
+		}
+		switch result.Attribute {
+		case "Name":
+			server.Name = result.Value
+		case "ListenAddress":
+			server.ListenAddress = listenHost(result.Value)
+		case "ListenPort":
+			server.ListenPort = result.Value
+		case "State":
+			server.State = result.Value
+		case "AdminServer":
+			server.AdminServer = strings.EqualFold(result.Value, "true")
+		}
+	}
+	servers := make([]managedServer, 0, len(byBean))
+	for _, server := range byBean {
+		if server.Name != "" {
+			servers = append(servers, *server)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
+
+// WebLogic reports the listen address as "host/ip", either part may be empty
+func listenHost(address string) string {
+	parts := strings.SplitN(address, "/", 2)
+	if parts[0] == "" && len(parts) == 2 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// Whether an admin server list is due for a refresh, used when the inventory
+// is only reloaded on file changes
+func (cli *PsoftJmxClient) adminExpansionDue(now time.Time) bool {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	for _, cached := range cli.admins {
+		if now.Sub(cached.refreshed) >= cli.Config.AdminExpansionInterval {
+			return true
+		}
+	}
+	return false
+}
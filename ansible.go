@@ -0,0 +1,336 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+)
+
+// Ansible variable read for each inventory column, unless remapped with AnsibleVars
+var defaultAnsibleVars = map[string]string{
+	"DomainName":  "domain_name",
+	"DomainType":  "domain_type",
+	"App":         "psoft_app",
+	"Env":         "psoft_env",
+	"Purpose":     "psoft_purpose",
+	"ServerName":  "server_name",
+	"HostName":    "host_name", // the inventory host name when not set
+	"ToolsVer":    "tools_version",
+	"WeblogicVer": "weblogic_version",
+	"JMXPort":     "jmx_port",
+	"JMXUser":     "jmx_user",
+	"JMXPassword": "jmx_password",
+	"Labels":      "psoft_labels",
+	"AdminServer": "weblogic_admin_server",
+}
+
+// A group as defined in the inventory, merged when defined in several places
+type ansibleGroup struct {
+	name    string
+	parents map[string]bool
+	vars    map[string]interface{}
+	hosts   map[string]map[string]interface{} // host vars set under this group
+}
+
+type ansibleInventory struct {
+	groups map[string]*ansibleGroup
+}
+
+// Read the PeopleSoft domains of an Ansible YAML inventory (hosts, groups,
+// vars and children).  Each host lists its domains in the domainsVar variable,
+// either as names or as maps of variables for that domain.  Variables are
+// resolved the Ansible way: all, then parent groups before child groups (by
+// depth, then name), then host vars, and finally the domain entry itself.
+// varNames maps inventory columns to other variable names than the defaults.
+func ReadAnsibleInventory(path string, domainsVar string, varNames map[string]string) ([]*PsoftDomain, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	srvlog.Debug("Reading file ", path)
+	if domainsVar == "" {
+		domainsVar = defaultAnsibleDomainsVar
+	}
+
+	var lineErrors []InventoryLineError
+	addError := func(format string, args ...interface{}) {
+		lineErrors = append(lineErrors, InventoryLineError{File: path, Err: fmt.Sprintf(format, args...)})
+	}
+
+	root := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &InventoryError{Errors: []InventoryLineError{{File: path, Err: err.Error()}}}
+	}
+	inv := &ansibleInventory{groups: make(map[string]*ansibleGroup)}
+	inv.group("all", "")
+	for name, def := range root {
+		parent := "all"
+		if name == "all" {
+			parent = ""
+		}
+		if err := inv.parseGroup(name, def, parent); err != nil {
+			addError("%s", err)
+		}
+	}
+
+	domainLines := make(map[string]string)
+	domainList := []*PsoftDomain{}
+	for _, host := range inv.hostNames() {
+		hostVars := inv.hostVars(host)
+		entries, ok := hostVars[domainsVar]
+		if !ok {
+			continue
+		}
+		list, ok := entries.([]interface{})
+		if !ok {
+			addError("host %s: %s must be a list", host, domainsVar)
+			continue
+		}
+		for i, entry := range list {
+			vars := make(map[string]interface{}, len(hostVars))
+			for key, value := range hostVars {
+				vars[key] = value
+			}
+			switch entry := entry.(type) {
+			case string:
+				vars[ansibleVarName("DomainName", varNames)] = entry
+			case map[interface{}]interface{}:
+				for key, value := range entry {
+					vars[fmt.Sprint(key)] = value
+				}
+			default:
+				addError("host %s: %s entry %d must be a domain name or a map of variables", host, domainsVar, i+1)
+				continue
+			}
+			source := fmt.Sprintf("%s[%d]", host, i)
+			domain, problems := ansibleDomain(host, vars, varNames)
+			for _, problem := range problems {
+				addError("%s: %s", source, problem)
+			}
+			if domain.DomainName == "" {
+				addError("%s: missing %s", source, ansibleVarName("DomainName", varNames))
+				continue
+			}
+			if firstSource, ok := domainLines[domain.DomainName]; ok {
+				addError("%s: duplicate DomainName %s, first defined on %s", source, domain.DomainName, firstSource)
+				continue
+			}
+			domainLines[domain.DomainName] = source
+			domain.source = path + ":" + source
+			domainList = append(domainList, domain)
+		}
+	}
+	if len(lineErrors) > 0 {
+		return nil, &InventoryError{Errors: lineErrors}
+	}
+	return domainList, nil
+}
+
+func ansibleVarName(column string, varNames map[string]string) string {
+	if name, ok := varNames[column]; ok {
+		return name
+	}
+	return defaultAnsibleVars[column]
+}
+
+// Build a target from the resolved variables of one domain entry
+func ansibleDomain(host string, vars map[string]interface{}, varNames map[string]string) (*PsoftDomain, []string) {
+	var problems []string
+	domain := &PsoftDomain{HostName: host}
+	for _, column := range inventoryColumns {
+		name := ansibleVarName(column.name, varNames)
+		value, ok := vars[name]
+		if name == "" || !ok || value == nil {
+			continue
+		}
+		if labels, ok := value.(map[interface{}]interface{}); ok && column.name == "Labels" {
+			domain.Labels = make(Labels, len(labels))
+			for key, labelValue := range labels {
+				domain.Labels[fmt.Sprint(key)] = fmt.Sprint(labelValue)
+			}
+			continue
+		}
+		switch value.(type) {
+		case string:
+		case int, int64, uint64, float64, bool:
+			// YAML reads 8.60 as a number and drops the 0
+			if column.name == "ToolsVer" || column.name == "WeblogicVer" {
+				problems = append(problems, fmt.Sprintf("%s must be quoted, ie \"%v\"", name, value))
+				continue
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s must be a single value", name))
+			continue
+		}
+		if err := column.set(domain, fmt.Sprint(value)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if domain.DomainName != "" {
+		problems = append(problems, validateDomain(domain)...)
+	}
+	return domain, problems
+}
+
+func (inv *ansibleInventory) group(name string, parent string) *ansibleGroup {
+	group, ok := inv.groups[name]
+	if !ok {
+		group = &ansibleGroup{
+			name:    name,
+			parents: make(map[string]bool),
+			vars:    make(map[string]interface{}),
+			hosts:   make(map[string]map[string]interface{}),
+		}
+		inv.groups[name] = group
+	}
+	if parent != "" {
+		group.parents[parent] = true
+	}
+	return group
+}
+
+// Add a group definition: vars, hosts and children, all optional
+func (inv *ansibleInventory) parseGroup(name string, def interface{}, parent string) error {
+	group := inv.group(name, parent)
+	if def == nil {
+		return nil
+	}
+	sections, ok := def.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("group %s must be a map of hosts, vars and children", name)
+	}
+	for key, value := range sections {
+		section := fmt.Sprint(key)
+		if value == nil {
+			continue
+		}
+		entries, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("group %s: %s must be a map", name, section)
+		}
+		switch section {
+		case "vars":
+			for varName, varValue := range entries {
+				group.vars[fmt.Sprint(varName)] = varValue
+			}
+		case "hosts":
+			for hostName, hostDef := range entries {
+				host := fmt.Sprint(hostName)
+				if group.hosts[host] == nil {
+					group.hosts[host] = make(map[string]interface{})
+				}
+				if hostDef == nil {
+					continue
+				}
+				hostVars, ok := hostDef.(map[interface{}]interface{})
+				if !ok {
+					return fmt.Errorf("group %s: host %s vars must be a map", name, host)
+				}
+				for varName, varValue := range hostVars {
+					group.hosts[host][fmt.Sprint(varName)] = varValue
+				}
+			}
+		case "children":
+			for childName, childDef := range entries {
+				if err := inv.parseGroup(fmt.Sprint(childName), childDef, name); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("group %s: unknown section %q", name, section)
+		}
+	}
+	return nil
+}
+
+func (inv *ansibleInventory) hostNames() []string {
+	seen := make(map[string]bool)
+	hosts := []string{}
+	for _, group := range inv.groups {
+		for host := range group.hosts {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Groups of a host with all their ancestors, in variable precedence order
+func (inv *ansibleInventory) hostGroups(host string) []*ansibleGroup {
+	member := make(map[string]bool)
+	var addWithParents func(name string)
+	addWithParents = func(name string) {
+		if member[name] {
+			return
+		}
+		member[name] = true
+		for parent := range inv.groups[name].parents {
+			addWithParents(parent)
+		}
+	}
+	for name, group := range inv.groups {
+		if _, ok := group.hosts[host]; ok {
+			addWithParents(name)
+		}
+	}
+	addWithParents("all")
+	groups := make([]*ansibleGroup, 0, len(member))
+	for name := range member {
+		groups = append(groups, inv.groups[name])
+	}
+	depths := make(map[string]int)
+	sort.Slice(groups, func(i, j int) bool {
+		iDepth, jDepth := inv.depth(groups[i].name, depths, nil), inv.depth(groups[j].name, depths, nil)
+		if iDepth != jDepth {
+			return iDepth < jDepth
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+// Longest path from the all group, a group nested deeper has precedence
+func (inv *ansibleInventory) depth(name string, depths map[string]int, visiting map[string]bool) int {
+	if depth, ok := depths[name]; ok {
+		return depth
+	}
+	if visiting == nil {
+		visiting = make(map[string]bool)
+	}
+	if visiting[name] {
+		return 0 // children loop, Ansible rejects these
+	}
+	visiting[name] = true
+	depth := 0
+	for parent := range inv.groups[name].parents {
+		if parentDepth := inv.depth(parent, depths, visiting) + 1; parentDepth > depth {
+			depth = parentDepth
+		}
+	}
+	visiting[name] = false
+	depths[name] = depth
+	return depth
+}
+
+// Variables of a host: group vars by precedence, then host vars
+func (inv *ansibleInventory) hostVars(host string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	groups := inv.hostGroups(host)
+	for _, group := range groups {
+		for key, value := range group.vars {
+			vars[key] = value
+		}
+	}
+	for _, group := range groups {
+		for key, value := range group.hosts[host] {
+			vars[key] = value
+		}
+	}
+	return vars
+}
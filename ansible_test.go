@@ -0,0 +1,82 @@
+package psoftjmx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadAnsibleInventoryPrecedence(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "hosts.yml", `all:
+  vars:
+    jmx_user: system
+    jmx_password: secret
+    psoft_env: DEV
+    psoft_purpose: all
+    server_name: PIA
+    tools_version: "8.58"
+  children:
+    hr:
+      vars: {psoft_app: HR, psoft_env: TST, domain_type: web}
+      children:
+        hr_prd:
+          vars: {psoft_env: PRD, jmx_port: 7001}
+          hosts:
+            192.0.2.11:
+              jmx_port: 7011
+              psoft_domains:
+                - HRWEB1
+                - {domain_name: HRWEB2, server_name: PIA2, tools_version: "8.60"}
+    b_site:
+      vars: {psoft_purpose: batch}
+      hosts:
+        192.0.2.11:
+    a_site:
+      vars: {psoft_purpose: online}
+      hosts:
+        192.0.2.11:
+`)
+	domains, err := ReadAnsibleInventory(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("got %d domains, want 2", len(domains))
+	}
+	byName := map[string]*PsoftDomain{}
+	for _, domain := range domains {
+		byName[domain.DomainName] = domain
+	}
+	first, second := byName["HRWEB1"], byName["HRWEB2"]
+	if first == nil || second == nil {
+		t.Fatalf("got %v, want HRWEB1 and HRWEB2", domains)
+	}
+	// all < hr < hr_prd, same depth by name, then host vars, then the entry
+	if first.Env != "PRD" || first.App != "HR" || first.JMXUser != "system" {
+		t.Errorf("HRWEB1 group vars: Env %s, App %s, JMXUser %s", first.Env, first.App, first.JMXUser)
+	}
+	if first.Purpose != "batch" {
+		t.Errorf("HRWEB1 Purpose = %s, want batch from b_site after a_site", first.Purpose)
+	}
+	if first.JMXPort != "7011" {
+		t.Errorf("HRWEB1 JMXPort = %s, want the host var 7011", first.JMXPort)
+	}
+	if first.HostName != "192.0.2.11" || first.ServerName != "PIA" || first.ToolsVer != "8.58" {
+		t.Errorf("HRWEB1: HostName %s, ServerName %s, ToolsVer %s", first.HostName, first.ServerName, first.ToolsVer)
+	}
+	if second.ServerName != "PIA2" || second.ToolsVer != "8.60" {
+		t.Errorf("HRWEB2 entry vars: ServerName %s, ToolsVer %s", second.ServerName, second.ToolsVer)
+	}
+}
+
+func TestReadAnsibleInventoryUnquotedVersion(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "hosts.yml", `all:
+  hosts:
+    192.0.2.11:
+      psoft_domains:
+        - {domain_name: HRWEB1, domain_type: web, jmx_port: 7001, tools_version: 8.60}
+`)
+	_, err := ReadAnsibleInventory(path, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "tools_version must be quoted") {
+		t.Errorf("got %v, want tools_version must be quoted", err)
+	}
+}
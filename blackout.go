@@ -0,0 +1,130 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Target fields a blackout selector can match, label.<name> matches a label
+var blackoutSelectorKeys = map[string]func(target *PsoftDomain) []string{
+	"domain":  func(t *PsoftDomain) []string { return []string{t.DomainName} },
+	"host":    func(t *PsoftDomain) []string { return []string{t.HostName, t.HostFQDN, shortName(t.HostName)} },
+	"app":     func(t *PsoftDomain) []string { return []string{t.App} },
+	"env":     func(t *PsoftDomain) []string { return []string{t.Env} },
+	"appenv":  func(t *PsoftDomain) []string { return []string{t.App + t.Env} },
+	"type":    func(t *PsoftDomain) []string { return []string{t.DomainType} },
+	"purpose": func(t *PsoftDomain) []string { return []string{t.Purpose} },
+}
+
+const blackoutLabelPrefix = "label."
+
+// One key=pattern condition of a blackout selector
+type blackoutCondition struct {
+	key     string
+	pattern string // lower case wildcard, ie hr*
+}
+
+// Structured blackout, every condition must match the target, ie
+// "host=pshrweb01*" or "app=HR;env=PRD;type=web" or "label.team=hcm"
+type blackoutSelector struct {
+	conditions []blackoutCondition
+}
+
+// Whether the first blackout column is a structured selector instead of a
+// domain name or an ...ENV<app><env> value
+func isBlackoutSelector(value string) bool {
+	return strings.Contains(value, "=")
+}
+
+func parseBlackoutSelector(value string) (*blackoutSelector, error) {
+	selector := &blackoutSelector{}
+	for _, part := range strings.Split(value, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid condition %q, expecting key=pattern", part)
+		}
+		key := strings.TrimSpace(keyValue[0])
+		// label names keep their case, like the inventory's Labels
+		if strings.HasPrefix(strings.ToLower(key), blackoutLabelPrefix) {
+			key = blackoutLabelPrefix + key[len(blackoutLabelPrefix):]
+		} else {
+			key = strings.ToLower(key)
+		}
+		pattern := strings.ToLower(strings.TrimSpace(keyValue[1]))
+		if _, ok := blackoutSelectorKeys[key]; !ok && !strings.HasPrefix(key, blackoutLabelPrefix) {
+			return nil, fmt.Errorf("unknown key %q, expecting domain, host, app, env, appenv, type, purpose or label.<name>", key)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		selector.conditions = append(selector.conditions, blackoutCondition{key: key, pattern: pattern})
+	}
+	if len(selector.conditions) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return selector, nil
+}
+
+// Wildcards match without regard to case
+func (selector *blackoutSelector) matches(target *PsoftDomain) bool {
+	for _, condition := range selector.conditions {
+		var values []string
+		if strings.HasPrefix(condition.key, blackoutLabelPrefix) {
+			label, ok := target.Labels[strings.TrimPrefix(condition.key, blackoutLabelPrefix)]
+			if !ok {
+				return false
+			}
+			values = []string{label}
+		} else {
+			values = blackoutSelectorKeys[condition.key](target)
+		}
+		matched := false
+		for _, value := range values {
+			if ok, _ := filepath.Match(condition.pattern, strings.ToLower(value)); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the blackout is in effect, a calendar blackout during an occurrence
+// of its event, any other until its EndTime
+func (blackout *BlackoutType) active(now time.Time) bool {
+	if blackout.event != nil {
+		return blackout.event.activeAt(now)
+	}
+	return !blackout.Expired(now)
+}
+
+// Whether the blackout applies to the target, with a structured selector or
+// the original exact domain name or trailing ENV<app><env> match
+func (blackout *BlackoutType) matches(target *PsoftDomain) bool {
+	if isBlackoutSelector(blackout.DomainEnv) {
+		selector := blackout.selector
+		if selector == nil {
+			var err error
+			if selector, err = parseBlackoutSelector(blackout.DomainEnv); err != nil {
+				return false
+			}
+		}
+		return selector.matches(target)
+	}
+	// the app and env follow the last "ENV"
+	appenv := ""
+	if i := strings.LastIndex(blackout.DomainEnv, "ENV"); i >= 0 {
+		appenv = blackout.DomainEnv[i+len("ENV"):]
+	}
+	return blackout.DomainEnv == target.DomainName || (appenv != "" && appenv == target.App+target.Env)
+}
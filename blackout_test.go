@@ -0,0 +1,73 @@
+package psoftjmx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlackoutMatches(t *testing.T) {
+	target := &PsoftDomain{
+		DomainName: "HRWEB1",
+		DomainType: "web",
+		App:        "HR",
+		Env:        "PRD",
+		Purpose:    "prod",
+		HostName:   "pshrweb01.example.edu",
+		HostFQDN:   "pshrweb01.example.edu",
+		Labels:     Labels{"team": "hcm", "Owner": "Ops"},
+	}
+	tests := []struct {
+		domainEnv string
+		want      bool
+	}{
+		{"HRWEB1", true},
+		{"HRWEB", false},
+		{"PSENVHRPRD", true},
+		{"ENVHRTST", false},
+		{"host=pshrweb01", true},
+		{"host=PSHRWEB*", true},
+		{"host=pscsweb*", false},
+		{"app=HR;env=PRD;type=web", true},
+		{"app=HR;env=PRD;type=app", false},
+		{"appenv=hr*", true},
+		{"purpose=prod", true},
+		{"domain=HRWEB?", true},
+		{"label.team=hcm", true},
+		{"label.team=scs", false},
+		{"label.Owner=ops", true},
+		{"LABEL.Owner=OPS", true},
+		{"label.owner=*", false},
+		{"label.Team=hcm", false},
+	}
+	for _, test := range tests {
+		blackout := &BlackoutType{DomainEnv: test.domainEnv}
+		if got := blackout.matches(target); got != test.want {
+			t.Errorf("%s matches = %t, want %t", test.domainEnv, got, test.want)
+		}
+	}
+}
+
+func TestReadBlackoutsSelectors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "blackout.txt",
+		"# selectors are parsed once\n"+
+			"app=HR;type=web||patching|jdoe\n"+
+			"HRWEB1||legacy\n")
+	blackouts, err := ReadBlackouts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blackouts) != 2 || blackouts[0].selector == nil || blackouts[1].selector != nil {
+		t.Fatalf("got %v, want a selector and a domain blackout", blackouts)
+	}
+	if blackouts[0].Author != "jdoe" {
+		t.Errorf("Author = %q, want jdoe", blackouts[0].Author)
+	}
+
+	for _, selector := range []string{"color=blue", "app=[hr", "app=HR;env", "=HR"} {
+		path := writeTestFile(t, dir, "blackout.txt", selector+"||invalid\n")
+		if _, err := ReadBlackouts(path); err == nil || !strings.Contains(err.Error(), "Invalid blackout") {
+			t.Errorf("%s: got %v, want an invalid blackout error", selector, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	defaultBreakerProbeInterval = 10 * time.Minute
+)
+
+const (
+	statusSuppressed = "Suppressed"
+)
+
+// failure streak of a single domain
+type breakerState struct {
+	failures    int       // consecutive Down results
+	downSince   time.Time // first failure of the streak
+	lastSuccess time.Time
+	lastError   string
+	nextProbe   time.Time // suppressed until then once failures reach the threshold
+}
+
+// Per domain circuit breakers, kept across GetMetrics calls so dead domains
+// stop using a worker and a full RMI timeout every cycle
+type domainBreakers struct {
+	threshold     int // consecutive failures before suppressing, 0 disables
+	probeInterval time.Duration
+	mu            sync.Mutex
+	states        map[string]*breakerState
+}
+
+func newDomainBreakers(threshold int, probeInterval time.Duration) *domainBreakers {
+	if probeInterval <= 0 {
+		probeInterval = defaultBreakerProbeInterval
+	}
+	return &domainBreakers{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		states:        make(map[string]*breakerState),
+	}
+}
+
+// Cached Down result when the domain is suppressed, nil when it should be queried
+func (b *domainBreakers) suppressed(domainName string, now time.Time) map[string]interface{} {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.states[domainName]
+	if !ok || state.failures < b.threshold || !now.Before(state.nextProbe) {
+		return nil
+	}
+	since := state.lastSuccess
+	if since.IsZero() {
+		since = state.downSince
+	}
+	mappedResults := make(map[string]interface{})
+	mappedResults["status"] = statusSuppressed
+	mappedResults["errorMsg"] = state.lastError
+	mappedResults["consecutive_failures"] = state.failures
+	mappedResults["since_last_success_s"] = int64(now.Sub(since).Seconds())
+	mappedResults["next_probe"] = state.nextProbe.UTC().Format(time.RFC3339)
+	return mappedResults
+}
+
+// Track the result of a query.  Only a Down domain counts as a failure,
+// config and collector errors aren't the domain's fault.
+func (b *domainBreakers) record(domainName string, status string, errorMsg string, now time.Time) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.states[domainName]
+	if !ok {
+		state = &breakerState{}
+		b.states[domainName] = state
+	}
+	switch status {
+	case "Up":
+		if state.failures >= b.threshold {
+			srvlog.Info("Circuit breaker: " + domainName + " is back up, no longer suppressed")
+		}
+		state.failures = 0
+		state.lastSuccess = now
+	case statusDown:
+		if state.failures == 0 {
+			state.downSince = now
+		}
+		state.failures++
+		state.lastError = errorMsg
+		if state.failures >= b.threshold {
+			if state.failures == b.threshold {
+				srvlog.Warn("Circuit breaker: suppressing "+domainName+" after consecutive failures", "failures", state.failures, "probe", b.probeInterval)
+			}
+			state.nextProbe = now.Add(b.probeInterval)
+		}
+	}
+}
+
+// Drop the failure streaks of domains no longer in the inventory
+func (b *domainBreakers) forget(domainList []*PsoftDomain) {
+	current := make(map[string]bool, len(domainList))
+	for _, domain := range domainList {
+		current[domain.DomainName] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for domainName := range b.states {
+		if !current[domainName] {
+			delete(b.states, domainName)
+		}
+	}
+}
+
+// circuit breakers shared by every GetMetrics call of the client
+func (cli *PsoftJmxClient) domainBreakers() *domainBreakers {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	if cli.breakers == nil {
+		cli.breakers = newDomainBreakers(cli.Config.BreakerThreshold, cli.Config.BreakerProbeInterval)
+	}
+	return cli.breakers
+}
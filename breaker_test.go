@@ -0,0 +1,90 @@
+package psoftjmx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDomainBreakers(t *testing.T) {
+	breakers := newDomainBreakers(3, time.Minute)
+	now := time.Date(2030, 1, 1, 6, 0, 0, 0, time.UTC)
+	breakers.record("HRWEB1", "Up", "", now)
+
+	// config and collector errors don't count
+	breakers.record("HRWEB1", statusConfigError, "invalid JMX user/password", now)
+	breakers.record("HRWEB1", statusCollectorError, "nailgun server unavailable", now)
+	for i := 1; i <= 3; i++ {
+		if result := breakers.suppressed("HRWEB1", now); result != nil {
+			t.Fatalf("suppressed after %d failures, want 3", i-1)
+		}
+		now = now.Add(time.Second)
+		breakers.record("HRWEB1", statusDown, "JMX connection refused", now)
+	}
+
+	// open: reported without a query until the probe
+	result := breakers.suppressed("HRWEB1", now.Add(30*time.Second))
+	if result == nil {
+		t.Fatal("not suppressed after 3 failures")
+	}
+	if result["status"] != statusSuppressed || result["consecutive_failures"] != 3 || result["errorMsg"] != "JMX connection refused" {
+		t.Errorf("suppressed result = %v", result)
+	}
+	if since := result["since_last_success_s"]; since != int64(33) {
+		t.Errorf("since_last_success_s = %v, want 33", since)
+	}
+
+	// half open: one probe after the interval, a failure suppresses again
+	now = now.Add(time.Minute)
+	if breakers.suppressed("HRWEB1", now) != nil {
+		t.Fatal("no probe after the probe interval")
+	}
+	breakers.record("HRWEB1", statusDown, "JMX connection refused", now)
+	if breakers.suppressed("HRWEB1", now.Add(time.Second)) == nil {
+		t.Fatal("a failed probe should suppress the domain again")
+	}
+
+	// a good probe closes it
+	now = now.Add(time.Minute)
+	breakers.record("HRWEB1", "Up", "", now)
+	if breakers.suppressed("HRWEB1", now.Add(time.Second)) != nil {
+		t.Error("still suppressed after an Up result")
+	}
+	breakers.record("HRWEB1", statusDown, "JMX connection refused", now)
+	if breakers.suppressed("HRWEB1", now.Add(time.Second)) != nil {
+		t.Error("suppressed after a single failure following a success")
+	}
+}
+
+func TestDomainBreakersDisabled(t *testing.T) {
+	breakers := newDomainBreakers(0, 0)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		breakers.record("HRWEB1", statusDown, "down", now)
+	}
+	if breakers.suppressed("HRWEB1", now) != nil || len(breakers.states) != 0 {
+		t.Error("a zero threshold should disable the breakers")
+	}
+}
+
+func TestDomainBreakersForget(t *testing.T) {
+	dir := t.TempDir()
+	inventory := writeTestFile(t, dir, "inventory.txt",
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n")
+	cli := &PsoftJmxClient{Config: &JMXConfig{PathInventoryFile: inventory, BreakerThreshold: 1}}
+	if err := cli.LoadTargets(); err != nil {
+		t.Fatal(err)
+	}
+	breakers := cli.domainBreakers()
+	breakers.record("HRWEB1", statusDown, "down", time.Now())
+	breakers.record("HRWEB2", statusDown, "down", time.Now())
+
+	writeTestFile(t, dir, "inventory.txt", "HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	cli.reloadInventory()
+	if _, ok := breakers.states["HRWEB2"]; ok {
+		t.Error("HRWEB2 was removed from the inventory but its breaker is kept")
+	}
+	if _, ok := breakers.states["HRWEB1"]; !ok {
+		t.Error("HRWEB1 lost its breaker")
+	}
+}
@@ -0,0 +1,660 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405"
+	// stop expanding a rule that never reaches the time asked for
+	calendarMaxPeriods = 100000
+)
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// Maps calendar events to a blackout target.  Category and Summary must both
+// match when set, Summary is a wildcard, both ignore case.
+type CalendarRule struct {
+	Category string // one of the event CATEGORIES, ie "PeopleSoft HR"
+	Summary  string // SUMMARY wildcard, ie "*HRPRD*"
+	Target   string // domain, ENV<app><env> or selector like host=pshrweb01*;type=web
+}
+
+// A VEVENT and its recurrence
+type calendarEvent struct {
+	uid          string
+	summary      string
+	organizer    string
+	categories   []string
+	start        time.Time
+	duration     time.Duration
+	rule         *recurrence
+	exdates      map[int64]bool // unix start of the cancelled occurrences
+	cancelled    bool
+	recurrenceID time.Time // set on an event replacing one occurrence of a recurring event
+}
+
+// The RRULE parts supported: FREQ, INTERVAL, COUNT, UNTIL, BYDAY and BYMONTHDAY
+type recurrence struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []icsWeekday
+	byMonthDay []int
+}
+
+// BYDAY entry, ie 1MO for the first Monday, -1FR for the last Friday, 0 for every one
+type icsWeekday struct {
+	ordinal int
+	weekday time.Weekday
+}
+
+// Read the events of an iCalendar file as blackouts, one per event and
+// matching rule.  A recurring event blacks out its targets during each of
+// its occurrences only, see BlackoutType.active.  Events using calendar
+// features that are not supported are skipped with a warning.
+func ReadBlackoutCalendar(path string, rules []CalendarRule) ([]*BlackoutType, error) {
+	for i, rule := range rules {
+		if rule.Target == "" {
+			return nil, fmt.Errorf("Calendar rule %d has no target", i+1)
+		}
+		if _, err := filepath.Match(strings.ToLower(rule.Summary), ""); err != nil {
+			return nil, fmt.Errorf("Calendar rule %d summary %q: %s", i+1, rule.Summary, err)
+		}
+		if isBlackoutSelector(rule.Target) {
+			if _, err := parseBlackoutSelector(rule.Target); err != nil {
+				return nil, fmt.Errorf("Calendar rule %d target %q: %s", i+1, rule.Target, err)
+			}
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	srvlog.Debug("Reading file ", path)
+	events, err := parseCalendar(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid calendar file %s: %s", path, err)
+	}
+
+	blackoutList := []*BlackoutType{}
+	for _, event := range events {
+		if event.cancelled {
+			continue
+		}
+		targets := map[string]bool{}
+		for _, rule := range rules {
+			if !rule.matches(event) || targets[rule.Target] {
+				continue
+			}
+			targets[rule.Target] = true
+			blackout := &BlackoutType{
+				DomainEnv: rule.Target,
+				Descr:     event.summary,
+				Author:    event.organizer,
+				event:     event,
+			}
+			if end, ok := event.lastEnd(); ok {
+				blackout.EndTime = formatEndTime(end)
+			}
+			if isBlackoutSelector(rule.Target) {
+				blackout.selector, _ = parseBlackoutSelector(rule.Target)
+			}
+			blackoutList = append(blackoutList, blackout)
+		}
+		if len(targets) == 0 {
+			srvlog.Debug("No calendar rule for event " + event.summary)
+		}
+	}
+	return blackoutList, nil
+}
+
+func (rule *CalendarRule) matches(event *calendarEvent) bool {
+	if rule.Category != "" {
+		found := false
+		for _, category := range event.categories {
+			if strings.EqualFold(category, rule.Category) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.Summary != "" {
+		if matched, _ := filepath.Match(strings.ToLower(rule.Summary), strings.ToLower(event.summary)); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// A content line, ie DTSTART;TZID=America/Chicago:20240601T060000
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Unfold the lines and collect the VEVENTs, other components are ignored.
+// An event replacing one occurrence of a recurring event (RECURRENCE-ID)
+// cancels that occurrence of the original.
+func parseCalendar(data []byte) ([]*calendarEvent, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []*calendarEvent
+	var current []icsProperty
+	depth := 0 // components nested in the VEVENT, ie VALARM
+	inEvent := false
+	for i, line := range lines {
+		prop, err := parseICSProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT") && !inEvent:
+			inEvent = true
+			current = nil
+		case prop.name == "BEGIN" && inEvent:
+			depth++
+		case prop.name == "END" && inEvent && depth > 0:
+			depth--
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT") && inEvent:
+			inEvent = false
+			event, err := newCalendarEvent(current)
+			if err != nil {
+				srvlog.Warn("Skipping calendar event: " + err.Error())
+				continue
+			}
+			events = append(events, event)
+		case inEvent && depth == 0:
+			current = append(current, prop)
+		}
+	}
+	if inEvent {
+		return nil, fmt.Errorf("VEVENT without END")
+	}
+
+	// RECURRENCE-ID events replace an occurrence of the event with the same UID
+	for _, override := range events {
+		if override.recurrenceID.IsZero() {
+			continue
+		}
+		for _, event := range events {
+			if event.uid == override.uid && event.recurrenceID.IsZero() {
+				event.exdates[override.recurrenceID.Unix()] = true
+			}
+		}
+	}
+	return events, nil
+}
+
+// name;param=value;param="quoted:value":value
+func parseICSProperty(line string) (icsProperty, error) {
+	prop := icsProperty{params: map[string]string{}}
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return prop, fmt.Errorf("no value in %q", line)
+	}
+	prop.value = line[colon+1:]
+	parts := strings.Split(line[:colon], ";")
+	prop.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		keyValue := strings.SplitN(param, "=", 2)
+		if len(keyValue) == 2 {
+			prop.params[strings.ToUpper(keyValue[0])] = strings.Trim(keyValue[1], `"`)
+		}
+	}
+	return prop, nil
+}
+
+func newCalendarEvent(props []icsProperty) (*calendarEvent, error) {
+	event := &calendarEvent{exdates: map[int64]bool{}}
+	var end time.Time
+	var hasDuration, allDay bool
+	var rrule string
+	for _, prop := range props {
+		var err error
+		switch prop.name {
+		case "UID":
+			event.uid = prop.value
+		case "SUMMARY":
+			event.summary = unescapeICSText(prop.value)
+		case "CATEGORIES":
+			for _, category := range splitICSList(prop.value) {
+				event.categories = append(event.categories, strings.TrimSpace(category))
+			}
+		case "ORGANIZER":
+			event.organizer = prop.params["CN"]
+			if event.organizer == "" {
+				event.organizer = strings.TrimPrefix(strings.TrimPrefix(prop.value, "mailto:"), "MAILTO:")
+			}
+		case "STATUS":
+			event.cancelled = strings.EqualFold(prop.value, "CANCELLED")
+		case "DTSTART":
+			event.start, allDay, err = parseICSTime(prop)
+		case "DTEND":
+			end, _, err = parseICSTime(prop)
+		case "DURATION":
+			event.duration, err = parseICSDuration(prop.value)
+			hasDuration = true
+		case "RRULE":
+			rrule = prop.value
+		case "EXDATE":
+			for _, value := range strings.Split(prop.value, ",") {
+				prop.value = value
+				var exdate time.Time
+				if exdate, _, err = parseICSTime(prop); err != nil {
+					break
+				}
+				event.exdates[exdate.Unix()] = true
+			}
+		case "RECURRENCE-ID":
+			event.recurrenceID, _, err = parseICSTime(prop)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q %s: %s", event.summary, prop.name, err)
+		}
+	}
+	if event.start.IsZero() {
+		return nil, fmt.Errorf("%q has no DTSTART", event.summary)
+	}
+	if !hasDuration {
+		if !end.IsZero() {
+			event.duration = end.Sub(event.start)
+		} else if allDay {
+			event.duration = event.start.AddDate(0, 0, 1).Sub(event.start)
+		}
+	}
+	if event.duration <= 0 {
+		return nil, fmt.Errorf("%q has no duration", event.summary)
+	}
+	if rrule != "" {
+		rule, err := parseRRule(rrule, event.start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("%q RRULE: %s", event.summary, err)
+		}
+		event.rule = rule
+	}
+	return event, nil
+}
+
+// A DATE or DATE-TIME value, UTC when it ends in Z, in TZID or else local time
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	location := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if loaded, err := time.LoadLocation(tzid); err == nil {
+			location = loaded
+		} else {
+			srvlog.Warn("Unknown calendar time zone " + tzid + ", using local time")
+		}
+	}
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len(icsDateFormat) {
+		date, err := time.ParseInLocation(icsDateFormat, value, location)
+		return date, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+	dateTime, err := time.ParseInLocation(icsDateTimeFormat, value, location)
+	return dateTime, false, err
+}
+
+// [+-]P[nW][nD][T[nH][nM][nS]], ie PT4H or P1DT12H
+func parseICSDuration(value string) (time.Duration, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+	}
+	value = strings.TrimLeft(value, "+-")
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	var duration time.Duration
+	number := ""
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+		case c == 'T':
+			units = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+		default:
+			unit, ok := units[c]
+			n, err := strconv.Atoi(number)
+			if !ok || err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			duration += time.Duration(n) * unit
+			number = ""
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return sign * duration, nil
+}
+
+// BYDAY entry: a two letter day with an optional ordinal from -5 to 5
+func parseICSWeekday(day string) (icsWeekday, error) {
+	if len(day) < 2 {
+		return icsWeekday{}, fmt.Errorf("invalid day %q", day)
+	}
+	weekday, ok := icsWeekdays[day[len(day)-2:]]
+	ordinal := 0
+	var err error
+	if len(day) > 2 {
+		ordinal, err = strconv.Atoi(day[:len(day)-2])
+		ok = ok && ordinal != 0
+	}
+	if err != nil || !ok || ordinal < -5 || ordinal > 5 {
+		return icsWeekday{}, fmt.Errorf("invalid day %q", day)
+	}
+	return icsWeekday{ordinal: ordinal, weekday: weekday}, nil
+}
+
+func parseRRule(value string, location *time.Location) (*recurrence, error) {
+	rule := &recurrence{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		key, val := strings.ToUpper(keyValue[0]), strings.ToUpper(keyValue[1])
+		var err error
+		switch key {
+		case "FREQ":
+			rule.freq = val
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(val)
+			if err == nil && rule.interval < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(val)
+		case "UNTIL":
+			rule.until, _, err = parseICSTime(icsProperty{value: val, params: map[string]string{}})
+			if err == nil && !strings.HasSuffix(val, "Z") {
+				rule.until = time.Date(rule.until.Year(), rule.until.Month(), rule.until.Day(),
+					rule.until.Hour(), rule.until.Minute(), rule.until.Second(), 0, location)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				var weekday icsWeekday
+				if weekday, err = parseICSWeekday(day); err != nil {
+					break
+				}
+				rule.byDay = append(rule.byDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, convErr := strconv.Atoi(day)
+				if convErr != nil || n == 0 || n < -31 || n > 31 {
+					err = fmt.Errorf("invalid day %q", day)
+					break
+				}
+				rule.byMonthDay = append(rule.byMonthDay, n)
+			}
+		case "WKST":
+			if val != "MO" {
+				err = fmt.Errorf("only MO is supported")
+			}
+		default:
+			err = fmt.Errorf("not supported")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s=%s: %s", key, val, err)
+		}
+	}
+	switch rule.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("FREQ=%s not supported", rule.freq)
+	}
+	if rule.freq == "YEARLY" && (len(rule.byDay) > 0 || len(rule.byMonthDay) > 0) {
+		return nil, fmt.Errorf("BYDAY and BYMONTHDAY not supported with FREQ=YEARLY")
+	}
+	for _, day := range rule.byDay {
+		if day.ordinal != 0 && rule.freq != "MONTHLY" {
+			return nil, fmt.Errorf("numbered BYDAY is only supported with FREQ=MONTHLY")
+		}
+	}
+	return rule, nil
+}
+
+// Call next with each occurrence start in order, from the period holding
+// from on, until next returns false, the rule ends or the periods start
+// after to (zero for no bound)
+func (event *calendarEvent) occurrences(from time.Time, to time.Time, next func(start time.Time) bool) {
+	if event.rule == nil {
+		if !event.exdates[event.start.Unix()] {
+			next(event.start)
+		}
+		return
+	}
+	rule := event.rule
+	first := 0
+	if rule.count == 0 && from.After(event.start) {
+		// skip the periods that ended before from, COUNT needs them all counted
+		first = int(from.Sub(event.start)/rule.maxPeriod()) - 1
+		if first < 0 {
+			first = 0
+		}
+	}
+	if !rule.until.IsZero() && (to.IsZero() || rule.until.Before(to)) {
+		to = rule.until
+	}
+	count := 0
+	for period := first; period < first+calendarMaxPeriods; period++ {
+		// nothing in period n starts before n-2 shortest periods after dtstart,
+		// so a rule that never matches stops here instead of at calendarMaxPeriods
+		if !to.IsZero() && event.start.Add(time.Duration(period-2)*rule.minPeriod()).After(to) {
+			return
+		}
+		for _, start := range rule.periodStarts(event.start, period) {
+			if start.Before(event.start) {
+				continue
+			}
+			if !rule.until.IsZero() && start.After(rule.until) {
+				return
+			}
+			count++
+			if !event.exdates[start.Unix()] && !next(start) {
+				return
+			}
+			if rule.count > 0 && count >= rule.count {
+				return
+			}
+		}
+	}
+}
+
+// longest possible period, with a DST change
+func (rule *recurrence) maxPeriod() time.Duration {
+	days := map[string]int{"DAILY": 1, "WEEKLY": 7, "MONTHLY": 31, "YEARLY": 366}[rule.freq]
+	return time.Duration(rule.interval) * (time.Duration(days)*24*time.Hour + time.Hour)
+}
+
+// shortest possible period, with a DST change
+func (rule *recurrence) minPeriod() time.Duration {
+	days := map[string]int{"DAILY": 1, "WEEKLY": 7, "MONTHLY": 28, "YEARLY": 365}[rule.freq]
+	return time.Duration(rule.interval) * (time.Duration(days)*24*time.Hour - time.Hour)
+}
+
+// Occurrence starts in the nth period after dtstart, sorted
+func (rule *recurrence) periodStarts(dtstart time.Time, period int) []time.Time {
+	year, month, day := dtstart.Date()
+	hour, min, sec := dtstart.Clock()
+	location := dtstart.Location()
+	n := period * rule.interval
+	var starts []time.Time
+	switch rule.freq {
+	case "DAILY":
+		start := time.Date(year, month, day+n, hour, min, sec, 0, location)
+		if len(rule.byDay) == 0 || rule.hasWeekday(start.Weekday()) {
+			starts = append(starts, start)
+		}
+	case "WEEKLY":
+		base := time.Date(year, month, day+7*n, hour, min, sec, 0, location)
+		if len(rule.byDay) == 0 {
+			return []time.Time{base}
+		}
+		monday := base.Day() - (int(base.Weekday())+6)%7
+		for _, byDay := range rule.byDay {
+			offset := (int(byDay.weekday) + 6) % 7
+			starts = append(starts, time.Date(base.Year(), base.Month(), monday+offset, hour, min, sec, 0, location))
+		}
+	case "MONTHLY":
+		monthStart := time.Date(year, month+time.Month(n), 1, hour, min, sec, 0, location)
+		daysInMonth := time.Date(monthStart.Year(), monthStart.Month()+1, 0, 0, 0, 0, 0, location).Day()
+		var days []int
+		for _, byDay := range rule.byDay {
+			days = append(days, monthWeekdays(monthStart, daysInMonth, byDay)...)
+		}
+		for _, monthDay := range rule.byMonthDay {
+			if monthDay < 0 {
+				monthDay = daysInMonth + monthDay + 1
+			}
+			days = append(days, monthDay)
+		}
+		if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+			days = []int{day}
+		}
+		for _, d := range days {
+			// months without that day are skipped
+			if d >= 1 && d <= daysInMonth {
+				starts = append(starts, time.Date(monthStart.Year(), monthStart.Month(), d, hour, min, sec, 0, location))
+			}
+		}
+	case "YEARLY":
+		start := time.Date(year+n, month, day, hour, min, sec, 0, location)
+		if start.Day() == day {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	return starts
+}
+
+func (rule *recurrence) hasWeekday(weekday time.Weekday) bool {
+	for _, byDay := range rule.byDay {
+		if byDay.weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// Days of the month for a BYDAY entry, every such weekday or the nth from the start or end
+func monthWeekdays(monthStart time.Time, daysInMonth int, byDay icsWeekday) []int {
+	var days []int
+	for d := 1 + (int(byDay.weekday)-int(monthStart.Weekday())+7)%7; d <= daysInMonth; d += 7 {
+		days = append(days, d)
+	}
+	switch {
+	case byDay.ordinal > 0 && byDay.ordinal <= len(days):
+		return days[byDay.ordinal-1 : byDay.ordinal]
+	case byDay.ordinal < 0 && -byDay.ordinal <= len(days):
+		return days[len(days)+byDay.ordinal : len(days)+byDay.ordinal+1]
+	case byDay.ordinal == 0:
+		return days
+	}
+	return nil
+}
+
+// Whether an occurrence of the event is under way
+func (event *calendarEvent) activeAt(now time.Time) bool {
+	active := false
+	event.occurrences(now.Add(-event.duration), now, func(start time.Time) bool {
+		if start.After(now) {
+			return false
+		}
+		active = now.Before(start.Add(event.duration))
+		return !active
+	})
+	return active
+}
+
+// End of the last occurrence, false for a rule without COUNT or UNTIL
+func (event *calendarEvent) lastEnd() (time.Time, bool) {
+	if event.rule != nil && event.rule.count == 0 && event.rule.until.IsZero() {
+		return time.Time{}, false
+	}
+	var last time.Time
+	event.occurrences(event.start, time.Time{}, func(start time.Time) bool {
+		last = start
+		return true
+	})
+	if last.IsZero() {
+		// every occurrence was cancelled
+		return event.start, true
+	}
+	return last.Add(event.duration), true
+}
+
+// CATEGORIES values are comma separated, commas in a value are escaped
+func splitICSList(value string) []string {
+	var values []string
+	current := ""
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			current += value[i : i+2]
+			i++
+		case value[i] == ',':
+			values = append(values, unescapeICSText(current))
+			current = ""
+		default:
+			current += value[i : i+1]
+		}
+	}
+	return append(values, unescapeICSText(current))
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
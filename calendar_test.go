@@ -0,0 +1,245 @@
+package psoftjmx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Events of a calendar holding the VEVENT bodies
+func parseTestCalendar(t *testing.T, events ...string) []*calendarEvent {
+	t.Helper()
+	data := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"
+	for _, event := range events {
+		data += "BEGIN:VEVENT\r\n" + strings.Replace(strings.TrimSpace(event), "\n", "\r\n", -1) + "\r\nEND:VEVENT\r\n"
+	}
+	parsed, err := parseCalendar([]byte(data + "END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(events) {
+		t.Fatalf("got %d events, want %d", len(parsed), len(events))
+	}
+	return parsed
+}
+
+// First starts of the event, formatted in its time zone
+func occurrenceStarts(event *calendarEvent, max int) []string {
+	var starts []string
+	event.occurrences(event.start, time.Time{}, func(start time.Time) bool {
+		starts = append(starts, start.Format("2006-01-02 15:04 MST"))
+		return len(starts) < max
+	})
+	return starts
+}
+
+func checkOccurrences(t *testing.T, name string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("%s occurrences:\n got %v\nwant %v", name, got, want)
+	}
+}
+
+func TestCalendarMonthlyByDay(t *testing.T) {
+	events := parseTestCalendar(t, `
+UID:monthly
+SUMMARY:HRPRD patching
+DTSTART;TZID=America/Chicago:20240101T060000
+DURATION:PT2H
+RRULE:FREQ=MONTHLY;BYDAY=1MO,-1FR;COUNT=6`)
+	checkOccurrences(t, "1MO,-1FR", occurrenceStarts(events[0], 10),
+		"2024-01-01 06:00 CST", "2024-01-26 06:00 CST",
+		"2024-02-05 06:00 CST", "2024-02-23 06:00 CST",
+		"2024-03-04 06:00 CST", "2024-03-29 06:00 CDT")
+	if end, ok := events[0].lastEnd(); !ok || end.Format("2006-01-02 15:04") != "2024-03-29 08:00" {
+		t.Errorf("lastEnd = %v %t, want 2024-03-29 08:00", end, ok)
+	}
+}
+
+func TestCalendarUntilAndCount(t *testing.T) {
+	events := parseTestCalendar(t, `
+UID:until
+DTSTART;TZID=America/Chicago:20240109T060000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20240118T120000Z`, `
+UID:until-local
+DTSTART;TZID=America/Chicago:20240109T060000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20240116T060000`, `
+UID:count
+DTSTART;TZID=America/Chicago:20240109T060000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH;COUNT=3`, `
+UID:open
+DTSTART;TZID=America/Chicago:20240109T060000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH`)
+	// UNTIL is inclusive, in UTC with Z and in the event's zone without
+	checkOccurrences(t, "UNTIL", occurrenceStarts(events[0], 10),
+		"2024-01-09 06:00 CST", "2024-01-11 06:00 CST", "2024-01-16 06:00 CST", "2024-01-18 06:00 CST")
+	checkOccurrences(t, "local UNTIL", occurrenceStarts(events[1], 10),
+		"2024-01-09 06:00 CST", "2024-01-11 06:00 CST", "2024-01-16 06:00 CST")
+	checkOccurrences(t, "COUNT", occurrenceStarts(events[2], 10),
+		"2024-01-09 06:00 CST", "2024-01-11 06:00 CST", "2024-01-16 06:00 CST")
+	if len(occurrenceStarts(events[3], 50)) != 50 {
+		t.Errorf("a rule without UNTIL or COUNT should not end")
+	}
+	if _, ok := events[3].lastEnd(); ok {
+		t.Errorf("a rule without UNTIL or COUNT has no lastEnd")
+	}
+}
+
+func TestCalendarExdateAndRecurrenceID(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	events := parseTestCalendar(t, `
+UID:nightly
+SUMMARY:HRPRD nightly
+DTSTART;TZID=America/Chicago:20240304T020000
+DTEND;TZID=America/Chicago:20240304T030000
+RRULE:FREQ=DAILY;COUNT=5
+EXDATE;TZID=America/Chicago:20240305T020000`, `
+UID:nightly
+SUMMARY:HRPRD nightly moved
+RECURRENCE-ID;TZID=America/Chicago:20240306T020000
+DTSTART;TZID=America/Chicago:20240306T100000
+DTEND;TZID=America/Chicago:20240306T110000`)
+	// cancelled and moved occurrences still count towards COUNT
+	checkOccurrences(t, "EXDATE and RECURRENCE-ID", occurrenceStarts(events[0], 10),
+		"2024-03-04 02:00 CST", "2024-03-07 02:00 CST", "2024-03-08 02:00 CST")
+	tests := []struct {
+		event  int
+		at     string
+		active bool
+	}{
+		{0, "2024-03-04 02:30", true},
+		{0, "2024-03-05 02:30", false},
+		{0, "2024-03-06 02:30", false},
+		{1, "2024-03-06 10:30", true},
+		{1, "2024-03-06 02:30", false},
+		{0, "2024-03-08 03:00", false},
+	}
+	for _, test := range tests {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", test.at, chicago)
+		if got := events[test.event].activeAt(at); got != test.active {
+			t.Errorf("%s at %s: active = %t, want %t", events[test.event].summary, test.at, got, test.active)
+		}
+	}
+}
+
+func TestCalendarDSTCrossing(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	events := parseTestCalendar(t, `
+UID:weekly
+DTSTART;TZID=America/Chicago:20240302T060000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;COUNT=3`, `
+UID:fall
+DTSTART;TZID=America/Chicago:20241102T013000
+DURATION:PT30M
+RRULE:FREQ=DAILY;COUNT=3`)
+	// the wall clock time is kept on both sides of the change
+	checkOccurrences(t, "spring forward", occurrenceStarts(events[0], 10),
+		"2024-03-02 06:00 CST", "2024-03-09 06:00 CST", "2024-03-16 06:00 CDT")
+	checkOccurrences(t, "fall back", occurrenceStarts(events[1], 10),
+		"2024-11-02 01:30 CDT", "2024-11-03 01:30 CDT", "2024-11-04 01:30 CST")
+	for at, want := range map[string]bool{
+		"2024-03-16 05:30": false, // 168 hours after the previous start
+		"2024-03-16 06:30": true,
+	} {
+		when, _ := time.ParseInLocation("2006-01-02 15:04", at, chicago)
+		if got := events[0].activeAt(when); got != want {
+			t.Errorf("weekly at %s: active = %t, want %t", at, got, want)
+		}
+	}
+}
+
+func TestCalendarNeverMatching(t *testing.T) {
+	events := parseTestCalendar(t, `
+UID:never
+DTSTART;TZID=America/Chicago:20240201T060000
+DURATION:PT1H
+RRULE:FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30`, `
+UID:never-count
+DTSTART;TZID=America/Chicago:20240201T060000
+DURATION:PT1H
+RRULE:FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30;COUNT=3`)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, event := range events {
+		active := true
+		// February never has a 30th, the periods after the query time aren't expanded
+		allocs := testing.AllocsPerRun(1, func() {
+			active = event.activeAt(at)
+		})
+		if active {
+			t.Errorf("%s is active", event.uid)
+		}
+		if allocs > 1000 {
+			t.Errorf("%s: activeAt made %.0f allocations, want the rule expanded only up to the query time", event.uid, allocs)
+		}
+	}
+}
+
+func TestParseRRuleInvalidDays(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=WEEKLY;BYDAY=",
+		"FREQ=WEEKLY;BYDAY=M",
+		"FREQ=WEEKLY;BYDAY=XX,MO",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=+XMO",
+		"FREQ=MONTHLY;BYMONTHDAY=",
+		"FREQ=MONTHLY;BYMONTHDAY=40,1",
+		"FREQ=MONTHLY;BYMONTHDAY=x",
+	} {
+		if _, err := parseRRule(rule, time.UTC); err == nil || !strings.Contains(err.Error(), "invalid day") {
+			t.Errorf("%s: got %v, want an invalid day error", rule, err)
+		}
+	}
+	rule, err := parseRRule("FREQ=MONTHLY;BYDAY=MO,2TU,-1FR", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []icsWeekday{{0, time.Monday}, {2, time.Tuesday}, {-1, time.Friday}}
+	for i, day := range rule.byDay {
+		if i >= len(want) || day != want[i] {
+			t.Errorf("BYDAY = %v, want %v", rule.byDay, want)
+			break
+		}
+	}
+}
+
+func TestCalendarBlackoutInEffect(t *testing.T) {
+	now := time.Now().UTC()
+	path := writeTestFile(t, t.TempDir(), "blackouts.ics", "BEGIN:VCALENDAR\r\n"+
+		"BEGIN:VEVENT\r\nUID:now\r\nSUMMARY:HRPRD patching\r\nCATEGORIES:PeopleSoft HR\r\n"+
+		"DTSTART:"+now.Add(-24*time.Hour-time.Hour).Format(icsDateTimeFormat)+"Z\r\nDURATION:PT2H\r\nRRULE:FREQ=DAILY\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:earlier\r\nSUMMARY:CSPRD patching\r\nCATEGORIES:PeopleSoft CS\r\n"+
+		"DTSTART:"+now.Add(-5*time.Hour).Format(icsDateTimeFormat)+"Z\r\nDURATION:PT1H\r\nRRULE:FREQ=DAILY\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	blackouts, err := ReadBlackoutCalendar(path, []CalendarRule{
+		{Category: "PeopleSoft HR", Target: "app=HR;type=web"},
+		{Category: "PeopleSoft CS", Target: "app=CS"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &JMXQueryRequest{Blackouts: blackouts}
+	for _, test := range []struct {
+		target PsoftDomain
+		want   bool
+	}{
+		{PsoftDomain{DomainName: "HRWEB1", DomainType: "web", App: "HR"}, true},
+		{PsoftDomain{DomainName: "HRAPP1", DomainType: "app", App: "HR"}, false},
+		{PsoftDomain{DomainName: "CSWEB1", DomainType: "web", App: "CS"}, false},
+	} {
+		if got := request.inBlackout(test.target); got != test.want {
+			t.Errorf("%s in blackout = %t, want %t", test.target.DomainName, got, test.want)
+		}
+	}
+}
@@ -4,23 +4,30 @@ package psoftjmx
 
 import (
 	"encoding/csv"
-	"errors"
 	"fmt"
-	"github.com/gocarina/gocsv"
 	"io"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type BlackoutType struct {
-	DomainEnv string // the domain or env the blackout applies to
-	EndTime   string // End of the blackout
+	DomainEnv string // the domain or env the blackout applies to, or a selector like host=pshrweb01*;type=web
+	EndTime   string // End of the blackout, ignored once past, blank for none
 	Descr     string // Reason, not used
+	Author    string // who set the blackout, optional
+	selector  *blackoutSelector
+	event     *calendarEvent // calendar blackouts are only active during the event's occurrences
 }
 
 type ExcludeDomainType struct {
 	DomainName string
+	EndTime    string // End of the exclusion, ignored once past, blank for none
+	Author     string // who excluded the domain, optional
+	Reason     string
 }
 
 type PsoftJmxClient struct {
@@ -30,7 +37,18 @@ type PsoftJmxClient struct {
 	ng         *NailGunServer
 	Blackouts  []*BlackoutType      // list of domains or envs in a blackout
 	Excludes   []*ExcludeDomainType // exiting domains to skip for monitoring, ie only used for peak loads
-
+	listMu     sync.Mutex           // guards DomainList, Blackouts and Excludes while files are reloaded
+	targetsMu  sync.Mutex           // one inventory load at a time, so an older read can't replace a newer one
+	statsMu    sync.Mutex
+	lastCycle  CycleStats    // summary of the last GetMetrics cycle
+	hosts      *hostLimiter  // requests in flight per host, shared by all pools
+	workers    chan struct{} // ConcurrentWorkers slots, shared by all pools
+	breakers   *domainBreakers
+	dns        *dnsCache
+	localHosts map[string]string // last local/remote decision per host, guarded by statsMu
+	watcher    *fileWatcher
+	admins     map[string]*adminExpansion // managed servers by admin DomainName, guarded by statsMu
+	sqlCache   *sqlInventoryCache         // last good SQL inventory, guarded by statsMu
 }
 
 // Uniquely defines a single PeopleSoft instance/domain
@@ -47,6 +65,35 @@ type PsoftDomain struct {
 	JMXPort     string
 	JMXUser     string
 	JMXPassword string
+	Labels      Labels // optional key=value,key=value column
+	ConnectHost string `csv:"-"` // address used for the JMX connection, LocalConnectAddress for local targets
+	HostFQDN    string `csv:"-"` // resolved fully qualified name of HostName
+	JMXDomain   string `csv:"-"` // real Tuxedo domain name used in the JMX URL, DomainName is the display name
+	AdminServer bool   // web target of a WebLogic admin server, its running managed servers are added as targets
+	source      string // inventory file:line the target was read from
+}
+
+// Tuxedo domain name to use in the JMX URL
+func (d *PsoftDomain) jmxDomain() string {
+	if d.JMXDomain != "" {
+		return d.JMXDomain
+	}
+	return d.DomainName
+}
+
+// address to open the JMX connection to
+func (d *PsoftDomain) connectHost() string {
+	if d.ConnectHost != "" {
+		return d.ConnectHost
+	}
+	return d.HostName
+}
+
+// keep the password out of debug logs
+func (d PsoftDomain) GoString() string {
+	d.JMXPassword = "****"
+	type plain PsoftDomain
+	return strings.Replace(fmt.Sprintf("%#v", plain(d)), "psoftjmx.plain", "psoftjmx.PsoftDomain", 1)
 }
 
 // called on new struct
@@ -75,151 +122,403 @@ func (cli *PsoftJmxClient) InitNailGunServer() error {
 }
 
 func (cli *PsoftJmxClient) LoadTargets() error {
-	_, err := os.Stat(cli.Config.PathInventoryFile)
+	cli.targetsMu.Lock()
+	defer cli.targetsMu.Unlock()
+	domainList, err := cli.readTargets()
 	if err != nil {
 		return err
 	}
-	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
-		r := csv.NewReader(in)
-		r.Comma = ' '
-		r.Comment = '#'
-		return r // Allows use pipe as delimiter
-	})
-	f, err2 := os.Open(cli.Config.PathInventoryFile)
-	if err2 != nil {
-		return errors.New("Failed to open Inventory file")
-	}
-	defer f.Close()
-	srvlog.Debug("Reading file ", cli.Config.PathInventoryFile)
+	cli.listMu.Lock()
+	cli.DomainList = domainList
+	cli.listMu.Unlock()
+	cli.domainBreakers().forget(domainList)
+
+	return nil
+}
 
-	domainList := []*PsoftDomain{}
-	gocsv.UnmarshalWithoutHeaders(f, &domainList)
+// Parse the inventory and resolve naming and local targets, without replacing DomainList
+func (cli *PsoftJmxClient) readTargets() ([]*PsoftDomain, error) {
+	files, err := expandInventoryPatterns(cli.Config.inventoryPatterns())
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 && len(cli.Config.DiscoverCfgHomes) == 0 && cli.Config.SQLInventoryQuery == "" {
+		return nil, fmt.Errorf("No inventory files found")
+	}
+	var sqlList []*PsoftDomain
+	if cli.Config.SQLInventoryQuery != "" {
+		sqlList, err = cli.sqlInventory()
+		if err != nil {
+			return nil, err
+		}
+	}
+	domainList, err := mergeInventories(cli.Config, sqlList, files)
+	if err != nil {
+		return nil, err
+	}
+	if cli.Config.PathCredentialsFile != "" {
+		rules, err := ReadCredentials(cli.Config.PathCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		rules.apply(domainList)
+	}
 	srvlog.Debug("Loaded these targets : " + fmt.Sprintf("%#v", domainList))
+	namer, err := newDomainNamer(cli.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid domain name template: %s", err)
+	}
 	// Check if we should only load local domains
 	currHost, _ := os.Hostname()
-	shortHost := strings.Split(currHost, ".")[0]
-	for i := len(domainList) - 1; i >= 0; i-- {
-		if cli.Config.ConcatenateDomainWithHost {
-			if cli.Config.UseLastXCharactersOfHost > 0 {
-				domainList[i].DomainName = domainList[i].DomainName + shortHost[len(shortHost)-cli.Config.UseLastXCharactersOfHost:]
-			} else {
-				domainList[i].DomainName = domainList[i].DomainName + shortHost
+	localHost := cli.localIdentity()
+	cli.prefetchDNS(localHost, currHost, domainList)
+	if cli.Config.LocalInventoryOnly {
+		// don't ask admin servers on other hosts for managed servers that are dropped anyway
+		localList := domainList[:0]
+		for _, domain := range domainList {
+			if domain.AdminServer {
+				isLocal, reason := localHost.isLocal(domain.HostName)
+				cli.logLocalMatch(domain.HostName, isLocal, reason)
+				if !isLocal {
+					continue
+				}
 			}
+			localList = append(localList, domain)
 		}
-		if domainList[i].HostName != currHost && domainList[i].HostName != shortHost {
+		domainList = localList
+	}
+	domainList = cli.expandAdminServers(domainList, localHost)
+	// the managed servers' hosts, the others are cached by now
+	cli.prefetchDNS(localHost, currHost, domainList)
+	for i := len(domainList) - 1; i >= 0; i-- {
+		if err = namer.rename(domainList[i], currHost); err != nil {
+			return nil, fmt.Errorf("Unable to build domain name for %s: %s", domainList[i].DomainName, err)
+		}
+		isLocal, reason := localHost.isLocal(domainList[i].HostName)
+		cli.logLocalMatch(domainList[i].HostName, isLocal, reason)
+		if !isLocal {
 			if cli.Config.LocalInventoryOnly {
-            copy(domainList[i:], domainList[i+1:])
-            domainList[len(domainList)-1] = nil
+				copy(domainList[i:], domainList[i+1:])
+				domainList[len(domainList)-1] = nil
 				domainList = domainList[:len(domainList)-1]
+				continue
 			}
+			domainList[i].ConnectHost = domainList[i].HostName
+			domainList[i].HostFQDN = cli.resolveFQDN(domainList[i].HostName)
 		} else {
-			domainList[i].HostName = "localhost"
+			// keep the inventory host as the identity, only connect locally
+			domainList[i].ConnectHost = cli.Config.LocalConnectAddress
+			domainList[i].HostFQDN = cli.resolveFQDN(currHost)
 		}
 	}
-	cli.DomainList = domainList
+	if err = checkUniqueNames(domainList); err != nil {
+		return nil, err
+	}
+	return domainList, nil
+}
 
-	return nil
+// Fully qualified name of a host: the reverse DNS name of its address with
+// the same short name, so short names and /etc/hosts entries resolve too,
+// else the canonical name.  Cached for localDNSCacheTTL like the local
+// identity.  Falls back to the name as given, IPs are kept.
+func (cli *PsoftJmxClient) resolveFQDN(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	dns := cli.dnsCache()
+	names := dns.lookup("fqdn:"+host, func() []string {
+		for _, addr := range dns.forward(host) {
+			for _, name := range dns.reverse(addr) {
+				name = strings.TrimSuffix(name, ".")
+				if strings.Contains(name, ".") && strings.EqualFold(shortName(name), shortName(host)) {
+					return []string{name}
+				}
+			}
+		}
+		// a virtual hostname, its address is registered under another name
+		if cname := dns.canonical(host); strings.Contains(cname, ".") {
+			return []string{cname}
+		}
+		return []string{host}
+	})
+	return names[0]
 }
 
-func (cli *PsoftJmxClient) LoadBlackouts() error {
-	blackoutList := []*BlackoutType{}
+// Parse the pipe delimited blackout file: DomainEnv|EndTime|Descr[|Author]
+func ReadBlackouts(path string) ([]*BlackoutType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	srvlog.Debug("Reading file ", path)
 
-	_, err := os.Stat(cli.Config.PathBlackoutFile)
+	r := csv.NewReader(f)
+	r.Comma = '|'                                      // Allows use pipe as delimiter
+	records, err := readMaintenanceRecords(r, path, 4) // Author was added later
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Invalid blackout file %s", err)
 	}
-	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
-		r := csv.NewReader(in)
-		r.Comma = '|'
-		r.Comment = '#'
-		return r // Allows use pipe as delimiter
-	})
-	f, err2 := os.Open(cli.Config.PathBlackoutFile)
-	if err2 != nil {
-		return errors.New("Failed to open Blackout file")
+	blackoutList := make([]*BlackoutType, 0, len(records))
+	for _, record := range records {
+		blackout := &BlackoutType{
+			DomainEnv: recordField(record, 0),
+			EndTime:   recordField(record, 1),
+			Descr:     recordField(record, 2),
+			Author:    recordField(record, 3),
+		}
+		if isBlackoutSelector(blackout.DomainEnv) {
+			blackout.selector, err = parseBlackoutSelector(blackout.DomainEnv)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid blackout %q in %s: %s", blackout.DomainEnv, path, err)
+			}
+		}
+		blackoutList = append(blackoutList, blackout)
+	}
+	return blackoutList, nil
+}
+
+// Parse the comma delimited exclusion file: DomainName[,EndTime,Author,Reason]
+func ReadExclusions(path string) ([]*ExcludeDomainType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
 	defer f.Close()
-	srvlog.Debug("Reading file ", cli.Config.PathBlackoutFile)
+	srvlog.Debug("Reading file ", path)
+
+	r := csv.NewReader(f)
+	records, err := readMaintenanceRecords(r, path, 4) // EndTime, Author and Reason are optional
+	if err != nil {
+		return nil, fmt.Errorf("Invalid exclusion file %s", err)
+	}
+	exclusionList := make([]*ExcludeDomainType, 0, len(records))
+	for _, record := range records {
+		exclusionList = append(exclusionList, &ExcludeDomainType{
+			DomainName: recordField(record, 0),
+			EndTime:    recordField(record, 1),
+			Author:     recordField(record, 2),
+			Reason:     recordField(record, 3),
+		})
+	}
+	return exclusionList, nil
+}
+
+// Records of a blackout or exclusion file, # lines are comments.  Trailing
+// fields are optional, a record with more than maxFields is an error with
+// its file:line.
+func readMaintenanceRecords(r *csv.Reader, path string, maxFields int) ([][]string, error) {
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	records := [][]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if len(record) > maxFields {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %d fields, expecting at most %d", path, line, len(record), maxFields)
+		}
+		records = append(records, record)
+	}
+}
 
-	gocsv.UnmarshalWithoutHeaders(f, &blackoutList)
+// Field i of a record, blank when the optional trailing field is missing
+func recordField(record []string, i int) string {
+	if i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+// Reload the blackouts and the calendar blackouts, the last good list is kept
+// if either file can't be read
+func (cli *PsoftJmxClient) LoadBlackouts() error {
+	blackoutList, err := ReadBlackouts(cli.Config.PathBlackoutFile)
+	if err != nil {
+		// the calendar can be the only source
+		if cli.Config.PathBlackoutCalendar == "" || !os.IsNotExist(err) {
+			return err
+		}
+		blackoutList = []*BlackoutType{}
+	}
+	if cli.Config.PathBlackoutCalendar != "" {
+		calendarList, err := ReadBlackoutCalendar(cli.Config.PathBlackoutCalendar, cli.Config.CalendarRules)
+		if err != nil {
+			return err
+		}
+		blackoutList = append(blackoutList, calendarList...)
+	}
 	srvlog.Debug("Loaded these blackout items : " + fmt.Sprintf("%#v", blackoutList))
+	cli.listMu.Lock()
 	cli.Blackouts = blackoutList
+	cli.listMu.Unlock()
 
 	return nil
 }
 
+// Reload the exclusions, the last good list is kept if the file can't be read
 func (cli *PsoftJmxClient) LoadExclusions() error {
-	_, err := os.Stat(cli.Config.PathExclusionFile)
+	exclusionList, err := ReadExclusions(cli.Config.PathExclusionFile)
 	if err != nil {
 		return err
 	}
-	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
-		r := csv.NewReader(in)
-		r.Comment = '#'
-		return r // Allows use pipe as delimiter
-	})
-	f, err2 := os.Open(cli.Config.PathExclusionFile)
-	if err2 != nil {
-		return errors.New("Failed to open Blackout file")
-	}
-	defer f.Close()
-	srvlog.Debug("Reading file ", cli.Config.PathExclusionFile)
-
-	exclusionList := []*ExcludeDomainType{}
-	gocsv.UnmarshalWithoutHeaders(f, &exclusionList)
 	srvlog.Debug("Loaded these excluded domains : " + fmt.Sprintf("%#v", exclusionList))
+	cli.listMu.Lock()
 	cli.Excludes = exclusionList
+	cli.listMu.Unlock()
 
 	return nil
 }
 
+// Blackout and exclusion files are optional, only log when one exists but can't be loaded
+func (cli *PsoftJmxClient) loadBlackoutsAndExclusions() {
+	if err := cli.LoadBlackouts(); err != nil {
+		if os.IsNotExist(err) {
+			srvlog.Debug("No blackout file: " + err.Error())
+		} else {
+			srvlog.Error("Unable to load blackouts, keeping the last good list: " + err.Error())
+		}
+	}
+	if err := cli.LoadExclusions(); err != nil {
+		if os.IsNotExist(err) {
+			srvlog.Debug("No exclusion file: " + err.Error())
+		} else {
+			srvlog.Error("Unable to load exclusions, keeping the last good list: " + err.Error())
+		}
+	}
+}
+
+// Current targets, blackouts and exclusions, safe to use while they are reloaded
+func (cli *PsoftJmxClient) snapshot() ([]*PsoftDomain, []*BlackoutType, []*ExcludeDomainType) {
+	cli.listMu.Lock()
+	defer cli.listMu.Unlock()
+	return cli.DomainList, cli.Blackouts, cli.Excludes
+}
+
 func (cli *PsoftJmxClient) GetMetrics() ([]map[string]interface{}, error) {
 
-	var requests []JMXQueryRequest
+	start := time.Now()
 
-	err := cli.LoadTargets()
+	// watched files are reloaded when they change
+	if !cli.watchingFiles() {
+		err := cli.LoadTargets()
+		if err != nil {
+			return make([]map[string]interface{}, 0), err
+		}
+		cli.loadBlackoutsAndExclusions()
+	}
+	domainList, _, _ := cli.snapshot()
+	srvlog.Debug("GetMetrics: Loaded these Targets : " + fmt.Sprintf("%#v", domainList))
+
+	requests, err := cli.buildRequests(domainList)
 	if err != nil {
 		return make([]map[string]interface{}, 0), err
 	}
-	srvlog.Debug("GetMetrics: Loaded these Targets : " + fmt.Sprintf("%#v", &cli.DomainList))
-	_ = cli.LoadBlackouts()
-	_ = cli.LoadExclusions()
+	responseMetrics := cli.runRequests(start, requests)
+	responseMetrics = append(responseMetrics, cli.collectorMetrics())
 
-	// setup a new pool of workers based on target domain list
-	jmxpool := NewPoolManager(len(cli.DomainList), cli.Config.ConcurrentWorkers)
-	srvlog.Debug("GetMetrics: Built pool for " + strconv.Itoa(cli.Config.ConcurrentWorkers))
-	// build data for the jobs
-	for i := 0; i < len(cli.DomainList); i++ {
+	//srvlog.Debug("GetMetrics: Final map : " + fmt.Sprintf("%#v", responseMetrics))
+	return responseMetrics, nil
+
+}
+
+// build the job for each target with the current blackouts and exclusions
+func (cli *PsoftJmxClient) buildRequests(domainList []*PsoftDomain) ([]JMXQueryRequest, error) {
+	var requests []JMXQueryRequest
+	var err error
+	_, blackouts, excludes := cli.snapshot()
+
+	for i := 0; i < len(domainList); i++ {
 		request := JMXQueryRequest{id: i}
-		request.QueryList, err = cli.Attributes.BuildQueryStrings(cli.DomainList[i].DomainType)
-		request.MetricsCfg = cli.Attributes.GetMetricConfig(cli.DomainList[i].DomainType)
+		request.QueryList, err = cli.Attributes.BuildQueryStrings(domainList[i].DomainType)
+		request.MetricsCfg = cli.Attributes.GetMetricConfig(domainList[i].DomainType)
 		request.NGAddress = cli.Config.NailgunServerConn
-		request.Blackouts = cli.Blackouts
-		request.Excludes = cli.Excludes
+		request.CredFile = cli.Config.JMXCredentialFile
+		request.Retry = cli.Config.Retry
+		request.Breakers = cli.domainBreakers()
+		request.Blackouts = blackouts
+		request.Excludes = excludes
 		if err != nil {
-			return make([]map[string]interface{}, 0), err
+			return nil, err
 		}
 
-		request.Target = *cli.DomainList[i]
-		srvlog.Debug("GetMetrics: Added target: " + fmt.Sprintf("%#v", cli.DomainList[i]))
+		request.Target = *domainList[i]
+		srvlog.Debug("GetMetrics: Added target: " + fmt.Sprintf("%#v", domainList[i]))
 		srvlog.Debug("GetMetrics: Added Config: ") // + fmt.Sprintf("%#v", request.MetricsCfg))
 		requests = append(requests, request)
 	}
+	return requests, nil
+}
+
+// per host limiter shared by every pool of the client
+func (cli *PsoftJmxClient) hostLimiter() *hostLimiter {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	if cli.hosts == nil {
+		cli.hosts = newHostLimiter(cli.Config.MaxRequestsPerHost)
+	}
+	return cli.hosts
+}
+
+// ConcurrentWorkers slots shared by every pool of the client, so overlapping
+// pools never run more than ConcurrentWorkers requests together
+func (cli *PsoftJmxClient) workerSlots() chan struct{} {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	if cli.workers == nil {
+		cli.workers = make(chan struct{}, cli.Config.ConcurrentWorkers)
+	}
+	return cli.workers
+}
+
+// send the jobs through a new pool of workers and record the cycle stats
+func (cli *PsoftJmxClient) runRequests(start time.Time, requests []JMXQueryRequest) []map[string]interface{} {
+	jmxresponse, busy := cli.runPool(requests)
+	cli.recordCycle(newCycleStats(start, cli.Config.ConcurrentWorkers, busy, jmxresponse))
+	return responseMetrics(jmxresponse)
+}
+
+// send the jobs through a new pool of workers, returns the responses and the worker busy time
+func (cli *PsoftJmxClient) runPool(requests []JMXQueryRequest) ([]JMXResponse, time.Duration) {
+	// setup a new pool of workers based on target domain list
+	jmxpool := NewPoolManager(len(requests), cli.Config.ConcurrentWorkers)
+	jmxpool.limiter = cli.hostLimiter()
+	jmxpool.slots = cli.workerSlots()
+	srvlog.Debug("GetMetrics: Built pool for " + strconv.Itoa(cli.Config.ConcurrentWorkers))
 
 	// send the jobs to process and NailGun connection to the pool
 	jmxresponse := jmxpool.RunJobs(requests)
-	responseMetrics := make([]map[string]interface{}, 0)
+	return jmxresponse, jmxpool.BusyTime()
+}
+
+func responseMetrics(jmxresponse []JMXResponse) []map[string]interface{} {
+	responseMetrics := make([]map[string]interface{}, 0, len(jmxresponse))
 	for _, eachMetric := range jmxresponse {
 		responseMetrics = append(responseMetrics, eachMetric.MetricResults)
 	}
+	return responseMetrics
+}
 
-	//srvlog.Debug("GetMetrics: Final map : " + fmt.Sprintf("%#v", responseMetrics))
-	return responseMetrics, nil
-
+func (cli *PsoftJmxClient) recordCycle(cycle CycleStats) {
+	cli.statsMu.Lock()
+	cli.lastCycle = cycle
+	cli.statsMu.Unlock()
+	srvlog.Info("GetMetrics: cycle completed", "duration_ms", cycle.Duration.Milliseconds(), "targets", cycle.Targets,
+		"ok", cycle.Ok, "failed", cycle.Failed, "skipped", cycle.Skipped, "utilization", cycle.Utilization)
 }
 
 func (cli *PsoftJmxClient) Close() error {
 
+	cli.StopWatching()
+	// nothing to stop when using an external Nailgun server
+	if cli.ng == nil {
+		return nil
+	}
 	cli.ng.StopNailGun()
 	return nil
 }
@@ -0,0 +1,279 @@
+package psoftjmx_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UMN-PeopleSoft/psoftjmx"
+	"github.com/UMN-PeopleSoft/psoftjmx/psoftjmxtest"
+)
+
+const testWebMetrics = `metrics:
+  - metricName: web.sessions
+    role: web
+    attrType: class
+    jmxClass: "com.bea:ServerRuntime=PIA,*"
+    jmxAttrName: OpenSessionsCurrentCount
+`
+
+// Client config for the inventory lines, with every metric file set up and
+// the Nailgun connection pointed at a fake server.  Inventory hosts are
+// TEST-NET addresses so nothing waits on DNS.
+func newTestClientConfig(t *testing.T, inventory string) (*psoftjmxtest.Server, *psoftjmx.JMXConfig) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"web.yaml", "app.yaml", "prc.yaml"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(testWebMetrics), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "inventory.txt"), []byte(inventory), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server, err := psoftjmxtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, &psoftjmx.JMXConfig{
+		PathInventoryFile:  filepath.Join(dir, "inventory.txt"),
+		PathBlackoutFile:   filepath.Join(dir, "blackout.txt"),
+		PathExclusionFile:  filepath.Join(dir, "exclude.txt"),
+		AttribWebMetrics:   filepath.Join(dir, "web.yaml"),
+		AttribAppMetrics:   filepath.Join(dir, "app.yaml"),
+		AttribPrcMetrics:   filepath.Join(dir, "prc.yaml"),
+		NailgunServerConn:  server.Address,
+		UseExternalNailgun: true,
+		ConcurrentWorkers:  3,
+	}
+}
+
+// Metric records by domain name, without the collector record
+func metricsByDomain(t *testing.T, metrics []map[string]interface{}) map[string]map[string]interface{} {
+	t.Helper()
+	byDomain := make(map[string]map[string]interface{})
+	for _, record := range metrics {
+		if domainName, ok := record["domain_name"].(string); ok && record["domain_type"] != "collector" {
+			byDomain[domainName] = record
+		}
+	}
+	return byDomain
+}
+
+func TestGetMetrics(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system wrong\n"+
+			"HRWEB3 web HR PRD prod PIA 192.0.2.13 8.60 12.2 7001 system secret\n")
+	sessions := psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12")
+	server.RequireCredentials("system", "secret")
+	server.Handle("//192.0.2.11:", psoftjmxtest.Respond(sessions))
+	server.Handle("//192.0.2.12:", psoftjmxtest.Respond(sessions))
+	slow := psoftjmxtest.Respond(sessions)
+	slow.Delay = 300 * time.Millisecond
+	server.Handle("//192.0.2.13:", slow)
+
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	metrics, err := client.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byDomain := metricsByDomain(t, metrics)
+	if len(byDomain) != 3 {
+		t.Fatalf("got %d target records, want 3: %v", len(byDomain), metrics)
+	}
+	if status := byDomain["HRWEB1"]["status"]; status != "Up" {
+		t.Errorf("HRWEB1 status = %v, want Up", status)
+	}
+	if sessions := byDomain["HRWEB1"]["web.sessions"]; sessions == nil {
+		t.Errorf("HRWEB1 has no web.sessions metric: %v", byDomain["HRWEB1"])
+	}
+	if status := byDomain["HRWEB2"]["status"]; status != "Config Error" {
+		t.Errorf("HRWEB2 status = %v, want Config Error", status)
+	}
+	if detail, _ := byDomain["HRWEB2"]["errorDetail"].(string); detail == "" {
+		t.Errorf("HRWEB2 has no errorDetail from the JMXQuery stderr")
+	}
+	if status := byDomain["HRWEB3"]["status"]; status != "Up" {
+		t.Errorf("HRWEB3 status = %v, want Up", status)
+	}
+	if ms, _ := byDomain["HRWEB3"]["collect_duration_ms"].(int64); ms < 300 {
+		t.Errorf("HRWEB3 collect_duration_ms = %v, want at least the 300ms delay", byDomain["HRWEB3"]["collect_duration_ms"])
+	}
+
+	cycle := client.LastCycle()
+	if cycle.Targets != 3 || cycle.Ok != 2 || cycle.Failed != 1 {
+		t.Errorf("cycle stats = %+v, want 3 targets, 2 ok and 1 failed", cycle)
+	}
+	if cycle.SlowestTarget != "HRWEB3" {
+		t.Errorf("slowest target = %s, want HRWEB3", cycle.SlowestTarget)
+	}
+	queries := 0
+	for _, command := range server.Commands() {
+		if command.Name == psoftjmxtest.JMXQueryCommand {
+			queries++
+		}
+	}
+	if queries != 3 {
+		t.Errorf("server got %d JMXQuery commands, want 3", queries)
+	}
+}
+
+func TestGetMetricsCredentials(t *testing.T) {
+	for _, credFile := range []bool{false, true} {
+		server, config := newTestClientConfig(t, "HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+		config.JMXCredentialFile = credFile
+		server.RequireCredentials("system", "secret")
+		server.HandleDefault(psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12")))
+		client, err := psoftjmx.NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics, err := client.GetMetrics()
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := metricsByDomain(t, metrics)["HRWEB1"]["status"]; status != "Up" {
+			t.Errorf("JMXCredentialFile %t: status = %v, want Up", credFile, status)
+		}
+		for _, command := range server.Commands() {
+			if command.Name != psoftjmxtest.JMXQueryCommand {
+				continue
+			}
+			if hasFile := command.Arg("-credfile") != ""; hasFile != credFile {
+				t.Errorf("JMXCredentialFile %t: got -credfile %t", credFile, hasFile)
+			}
+			if hasPassword := command.Arg("-p") != ""; hasPassword == credFile {
+				t.Errorf("JMXCredentialFile %t: got -p %t", credFile, hasPassword)
+			}
+		}
+	}
+}
+
+func TestGetMetricsSkipped(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n"+
+			"HRWEB3 web HR PRD prod PIA 192.0.2.13 8.60 12.2 7001 system secret\n")
+	server.HandleDefault(psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12")))
+	if err := ioutil.WriteFile(config.PathBlackoutFile, []byte("HRWEB2||patching\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(config.PathExclusionFile, []byte("HRWEB3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	metrics, err := client.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDomain := metricsByDomain(t, metrics)
+	if status := byDomain["HRWEB2"]["Status"]; status != "Blackout" {
+		t.Errorf("HRWEB2 Status = %v, want Blackout", status)
+	}
+	if status := byDomain["HRWEB3"]["Status"]; status != "Excluded" {
+		t.Errorf("HRWEB3 Status = %v, want Excluded", status)
+	}
+	if cycle := client.LastCycle(); cycle.Ok != 1 || cycle.Skipped != 2 || cycle.Failed != 0 {
+		t.Errorf("cycle stats = %+v, want 1 ok and 2 skipped", cycle)
+	}
+}
+
+func TestLocalInventoryOnlyAdminServers(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"DomainName DomainType HostName JMXPort JMXUser JMXPassword ServerName AdminServer\n"+
+			"HRADM web 127.0.0.1 7001 system secret PIA Y\n"+
+			"CSADM web 192.0.2.11 7001 system secret PIA Y\n")
+	config.LocalInventoryOnly = true
+	bean := "com.bea:Name=%s,Type=ServerRuntime"
+	server.Handle("//localhost:7001", psoftjmxtest.Respond(
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA"), "Name", "PIA"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA"), "AdminServer", "true"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA"), "State", "RUNNING"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA1"), "Name", "PIA1"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA1"), "ListenAddress", "/127.0.0.1"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA1"), "ListenPort", "8001"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA1"), "State", "RUNNING"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA2"), "Name", "PIA2"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA2"), "ListenAddress", "/192.0.2.21"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA2"), "ListenPort", "8001"),
+		psoftjmxtest.Result(fmt.Sprintf(bean, "PIA2"), "State", "RUNNING"),
+	))
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	names := []string{}
+	for _, domain := range client.DomainList {
+		names = append(names, domain.DomainName)
+	}
+	sort.Strings(names)
+	if want := []string{"HRADM", "HRADM_PIA1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("targets = %v, want %v", names, want)
+	}
+	for _, command := range server.Commands() {
+		if strings.Contains(command.Arg("-url"), "192.0.2.11") {
+			t.Errorf("remote admin server was asked for its managed servers: %s", command.Arg("-url"))
+		}
+	}
+}
+
+func TestGetMetricsMaxRequestsPerHost(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA1 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA2 192.0.2.11 8.60 12.2 7011 system secret\n"+
+			"HRWEB3 web HR PRD prod PIA3 192.0.2.11 8.60 12.2 7021 system secret\n"+
+			"HRWEB4 web HR PRD prod PIA4 192.0.2.11 8.60 12.2 7031 system secret\n"+
+			"CSWEB1 web CS PRD prod PIA1 192.0.2.12 8.60 12.2 7001 system secret\n"+
+			"CSWEB2 web CS PRD prod PIA2 192.0.2.12 8.60 12.2 7011 system secret\n")
+	config.ConcurrentWorkers = 4
+	config.MaxRequestsPerHost = 1
+	slow := psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12"))
+	slow.Delay = 50 * time.Millisecond
+	server.HandleDefault(slow)
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.GetMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	if cycle := client.LastCycle(); cycle.Ok != 6 {
+		t.Errorf("cycle stats = %+v, want 6 ok", cycle)
+	}
+	// one request per host at a time, the two hosts in parallel
+	if active := server.MaxActive(psoftjmxtest.JMXQueryCommand); active != 2 {
+		t.Errorf("server saw %d concurrent queries, want 2", active)
+	}
+	// round robin: the second host doesn't wait behind the first host's queue
+	var hosts []string
+	for _, command := range server.Commands() {
+		if command.Name != psoftjmxtest.JMXQueryCommand {
+			continue
+		}
+		url := command.Arg("-url")
+		hosts = append(hosts, url[strings.Index(url, "//")+2:strings.LastIndex(url, ":")])
+	}
+	if len(hosts) != 6 || hosts[0] == hosts[1] || hosts[2] == hosts[3] {
+		t.Errorf("queries by host = %v, want the hosts alternating while both have targets", hosts)
+	}
+}
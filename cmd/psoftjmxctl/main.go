@@ -0,0 +1,166 @@
+// Manage psoftjmx blackouts and exclusions from change automation, ie
+//
+//	psoftjmxctl -blackouts /etc/psoftjmx/blackout.txt blackout add -target host=pshrweb01* -until 4h -reason "OS patching"
+//	psoftjmxctl -exclusions /etc/psoftjmx/exclude.txt exclusion remove -domain HRPRD1
+//
+// The files are replaced in one step, collectors pick up the change on their
+// next cycle or file reload.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/UMN-PeopleSoft/psoftjmx"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: psoftjmxctl [-blackouts file] [-exclusions file] <blackout|exclusion> <add|list|remove> [flags]
+
+  blackout add -target <domain|ENV<app><env>|selector> [-until <duration|time>] [-author name] [-reason text]
+  blackout remove -target <domain|ENV<app><env>|selector>
+  blackout list [-all]
+  exclusion add -domain <domain> [-until <duration|time>] [-author name] [-reason text]
+  exclusion remove -domain <domain>
+  exclusion list [-all]
+
+-until is a duration from now (4h, 90m) or an end time (2024-06-01 06:00, RFC3339).
+
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	blackoutFile := flag.String("blackouts", "blackout.txt", "blackout file")
+	exclusionFile := flag.String("exclusions", "exclude.txt", "exclusion file")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+	kind, action, args := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+
+	var err error
+	switch kind {
+	case "blackout":
+		err = blackout(*blackoutFile, action, args)
+	case "exclusion":
+		err = exclusion(*exclusionFile, action, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "psoftjmxctl: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func blackout(path string, action string, args []string) error {
+	flags := flag.NewFlagSet("blackout "+action, flag.ExitOnError)
+	target := flags.String("target", "", "domain, ENV<app><env> or selector like host=pshrweb01*;type=web")
+	until := flags.String("until", "", "duration from now or end time, none by default")
+	author := flags.String("author", currentUser(), "who sets the blackout")
+	reason := flags.String("reason", "", "why")
+	all := flags.Bool("all", false, "list expired blackouts too")
+	flags.Parse(args)
+
+	switch action {
+	case "add":
+		endTime, err := parseUntil(*until)
+		if err != nil {
+			return err
+		}
+		return psoftjmx.SetBlackout(path, psoftjmx.BlackoutType{DomainEnv: *target, EndTime: endTime, Descr: *reason, Author: *author})
+	case "remove":
+		removed, err := psoftjmx.DeleteBlackout(path, *target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d blackout(s) removed\n", removed)
+		return nil
+	case "list":
+		blackoutList, err := psoftjmx.ReadBlackouts(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TARGET\tUNTIL\tAUTHOR\tREASON")
+		for _, b := range blackoutList {
+			if *all || !b.Expired(time.Now()) {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.DomainEnv, b.EndTime, b.Author, b.Descr)
+			}
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown blackout action %q, expecting add, list or remove", action)
+}
+
+func exclusion(path string, action string, args []string) error {
+	flags := flag.NewFlagSet("exclusion "+action, flag.ExitOnError)
+	domain := flags.String("domain", "", "domain name")
+	until := flags.String("until", "", "duration from now or end time, none by default")
+	author := flags.String("author", currentUser(), "who excludes the domain")
+	reason := flags.String("reason", "", "why")
+	all := flags.Bool("all", false, "list expired exclusions too")
+	flags.Parse(args)
+
+	switch action {
+	case "add":
+		endTime, err := parseUntil(*until)
+		if err != nil {
+			return err
+		}
+		return psoftjmx.SetExclusion(path, psoftjmx.ExcludeDomainType{DomainName: *domain, EndTime: endTime, Author: *author, Reason: *reason})
+	case "remove":
+		removed, err := psoftjmx.DeleteExclusion(path, *domain)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d exclusion(s) removed\n", removed)
+		return nil
+	case "list":
+		exclusionList, err := psoftjmx.ReadExclusions(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DOMAIN\tUNTIL\tAUTHOR\tREASON")
+		for _, e := range exclusionList {
+			if *all || !e.Expired(time.Now()) {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.DomainName, e.EndTime, e.Author, e.Reason)
+			}
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown exclusion action %q, expecting add, list or remove", action)
+}
+
+// -until as a duration from now or an end time, written as RFC3339
+func parseUntil(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(duration).Format(time.RFC3339), nil
+	}
+	endTime, ok := psoftjmx.ParseEndTime(value)
+	if !ok {
+		return "", fmt.Errorf("invalid -until %q, expecting a duration like 4h or a time like 2024-06-01 06:00", value)
+	}
+	return endTime.Format(time.RFC3339), nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,126 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// JMX credentials for the targets matching every criteria set on the rule
+type CredentialRule struct {
+	App        string `yaml:"app"`
+	Env        string `yaml:"env"`
+	DomainType string `yaml:"domainType"`
+	Domain     string `yaml:"domain"` // DomainName wildcard, ie HR*
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+}
+
+// Credential rules, pulled from the PathCredentialsFile yaml file
+type CredentialRules struct {
+	Rules []CredentialRule `yaml:"credentials"`
+}
+
+// keep the passwords out of debug logs
+func (rule CredentialRule) GoString() string {
+	return fmt.Sprintf("psoftjmx.CredentialRule{App:%q, Env:%q, DomainType:%q, Domain:%q, User:%q, Password:\"****\"}",
+		rule.App, rule.Env, rule.DomainType, rule.Domain, rule.User)
+}
+
+// Parse and validate a credentials file
+func ReadCredentials(path string) (*CredentialRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	srvlog.Debug("Reading file ", path)
+	rules := &CredentialRules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %s", path, err)
+	}
+	for i, rule := range rules.Rules {
+		if rule.User == "" && rule.Password == "" {
+			return nil, fmt.Errorf("Invalid credentials file %s: rule %d has no user or password", path, i+1)
+		}
+		if rule.Domain != "" {
+			if _, err := filepath.Match(rule.Domain, ""); err != nil {
+				return nil, fmt.Errorf("Invalid credentials file %s: rule %d domain %q: %s", path, i+1, rule.Domain, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (rule *CredentialRule) matches(domain *PsoftDomain) bool {
+	if rule.App != "" && !strings.EqualFold(rule.App, domain.App) {
+		return false
+	}
+	if rule.Env != "" && !strings.EqualFold(rule.Env, domain.Env) {
+		return false
+	}
+	if rule.DomainType != "" && !strings.EqualFold(rule.DomainType, domain.DomainType) {
+		return false
+	}
+	if rule.Domain != "" {
+		if matched, _ := filepath.Match(rule.Domain, domain.DomainName); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// How narrow the rule is: an exact domain name beats any mix of the other
+// criteria, each exact app, env or type beats a domain wildcard, and a bare
+// "*" counts for nothing like a rule without criteria
+func (rule *CredentialRule) specificity() int {
+	score := 0
+	for _, criteria := range []string{rule.App, rule.Env, rule.DomainType} {
+		if criteria != "" {
+			score += 2
+		}
+	}
+	switch {
+	case rule.Domain == "" || rule.Domain == "*":
+	case strings.ContainsAny(rule.Domain, "*?[\\"):
+		score++
+	default:
+		score += 8
+	}
+	return score
+}
+
+// Most specific rule matching the target, the first one listed on a tie
+func (rules *CredentialRules) find(domain *PsoftDomain) *CredentialRule {
+	var best *CredentialRule
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.matches(domain) && (best == nil || rule.specificity() > best.specificity()) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// Fill the blank JMXUser/JMXPassword of the targets from the matching rule
+func (rules *CredentialRules) apply(domainList []*PsoftDomain) {
+	for _, domain := range domainList {
+		if domain.JMXUser != "" && domain.JMXPassword != "" {
+			continue
+		}
+		rule := rules.find(domain)
+		if rule == nil {
+			srvlog.Debug("No credentials rule for " + domain.DomainName)
+			continue
+		}
+		if domain.JMXUser == "" {
+			domain.JMXUser = rule.User
+		}
+		if domain.JMXPassword == "" {
+			domain.JMXPassword = rule.Password
+		}
+	}
+}
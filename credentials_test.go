@@ -0,0 +1,70 @@
+package psoftjmx
+
+import (
+	"testing"
+)
+
+func TestCredentialRulesPrecedence(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "credentials.yml", `credentials:
+  - {user: default, password: default}
+  - {domain: "*", user: star, password: star}
+  - {app: HR, user: hr, password: hr}
+  - {domain: "HR*", user: hrglob, password: hrglob}
+  - {app: HR, env: PRD, user: hrprd, password: hrprd}
+  - {app: HR, env: PRD, domainType: web, user: hrprdweb, password: hrprdweb}
+  - {app: HR, env: PRD, domainType: web, user: second, password: second}
+  - {domain: HRWEB9, user: hrweb9, password: hrweb9}
+  - {app: CS, domain: "CS*", user: csglob, password: csglob}
+  - {env: TST, user: tst, password: tst}
+`)
+	rules, err := ReadCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target PsoftDomain
+		user   string
+	}{
+		{PsoftDomain{DomainName: "FSAPP1", App: "FS", Env: "DEV", DomainType: "app"}, "default"},
+		{PsoftDomain{DomainName: "HRAPP1", App: "HR", Env: "DEV", DomainType: "app"}, "hr"},
+		{PsoftDomain{DomainName: "HRAPP1", App: "HR", Env: "PRD", DomainType: "app"}, "hrprd"},
+		{PsoftDomain{DomainName: "HRWEB1", App: "HR", Env: "PRD", DomainType: "web"}, "hrprdweb"},
+		{PsoftDomain{DomainName: "HRWEB9", App: "HR", Env: "PRD", DomainType: "web"}, "hrweb9"},
+		{PsoftDomain{DomainName: "HRPRC1", App: "HX", Env: "DEV", DomainType: "prc"}, "hrglob"},
+		{PsoftDomain{DomainName: "CSAPP1", App: "CS", Env: "TST", DomainType: "app"}, "csglob"},
+		{PsoftDomain{DomainName: "CSAPP1", App: "SA", Env: "TST", DomainType: "app"}, "tst"},
+	}
+	for _, test := range tests {
+		rule := rules.find(&test.target)
+		if rule == nil || rule.User != test.user {
+			t.Errorf("%s %s %s %s: got %#v, want %s", test.target.DomainName, test.target.App, test.target.Env, test.target.DomainType, rule, test.user)
+		}
+	}
+}
+
+func TestCredentialRulesApply(t *testing.T) {
+	rules := &CredentialRules{Rules: []CredentialRule{
+		{App: "HR", User: "hr", Password: "hrsecret"},
+		{App: "CS", User: "cs"},
+	}}
+	domainList := []*PsoftDomain{
+		{DomainName: "HRWEB1", App: "HR", JMXUser: "system", JMXPassword: "inventory"},
+		{DomainName: "HRWEB2", App: "HR", JMXUser: "system"},
+		{DomainName: "HRWEB3", App: "HR", JMXPassword: "inventory"},
+		{DomainName: "CSWEB1", App: "CS"},
+		{DomainName: "FSWEB1", App: "FS"},
+	}
+	rules.apply(domainList)
+	want := [][2]string{
+		{"system", "inventory"},
+		{"system", "hrsecret"},
+		{"hr", "inventory"},
+		{"cs", ""},
+		{"", ""},
+	}
+	for i, domain := range domainList {
+		if domain.JMXUser != want[i][0] || domain.JMXPassword != want[i][1] {
+			t.Errorf("%s: got %s/%s, want %s/%s", domain.DomainName, domain.JMXUser, domain.JMXPassword, want[i][0], want[i][1])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"time"
+)
+
+// Summary of one collection cycle, used to size ConcurrentWorkers and spot slow targets
+type CycleStats struct {
+	Start           time.Time
+	Duration        time.Duration // wall clock time of the whole cycle
+	Targets         int
+	Ok              int
+	Failed          int
+	Skipped         int // in blackout, excluded or suppressed
+	Workers         int
+	WorkerBusy      time.Duration // total time workers spent on requests
+	Utilization     float64       // WorkerBusy as a fraction of Workers * Duration
+	SlowestTarget   string
+	SlowestDuration time.Duration
+}
+
+// Build the cycle summary from the pool responses
+func newCycleStats(start time.Time, workers int, busy time.Duration, responses []JMXResponse) CycleStats {
+	stats := CycleStats{
+		Start:      start,
+		Duration:   time.Since(start),
+		Targets:    len(responses),
+		Workers:    workers,
+		WorkerBusy: busy,
+	}
+	for _, response := range responses {
+		switch {
+		case response.Skipped:
+			stats.Skipped++
+		case response.MetricResults["status"] == "Up":
+			stats.Ok++
+		default:
+			stats.Failed++
+		}
+		if response.Duration > stats.SlowestDuration {
+			stats.SlowestDuration = response.Duration
+			stats.SlowestTarget = response.JMXQueryRequest.Target.DomainName
+		}
+	}
+	if stats.Workers > 0 && stats.Duration > 0 {
+		stats.Utilization = float64(stats.WorkerBusy) / float64(time.Duration(stats.Workers)*stats.Duration)
+	}
+	return stats
+}
+
+// Summary of the most recent GetMetrics cycle
+func (cli *PsoftJmxClient) LastCycle() CycleStats {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	return cli.lastCycle
+}
+
+// add the cycle summary to the collector record
+func (stats CycleStats) addTo(collector map[string]interface{}) {
+	if stats.Start.IsZero() {
+		return
+	}
+	collector["cycle.timestamp"] = stats.Start.UTC().Format(time.RFC3339Nano)
+	collector["cycle.duration_ms"] = stats.Duration.Milliseconds()
+	collector["cycle.targets"] = stats.Targets
+	collector["cycle.ok"] = stats.Ok
+	collector["cycle.failed"] = stats.Failed
+	collector["cycle.skipped"] = stats.Skipped
+	collector["cycle.workers"] = stats.Workers
+	collector["cycle.worker_busy_ms"] = stats.WorkerBusy.Milliseconds()
+	collector["cycle.worker_utilization"] = float64(int(stats.Utilization*10000)) / 10000
+	collector["cycle.slowest_target"] = stats.SlowestTarget
+	collector["cycle.slowest_ms"] = stats.SlowestDuration.Milliseconds()
+}
@@ -0,0 +1,177 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	weblogicDefaultListenPort = "7001" // listen-port is left out of config.xml when it is the default
+)
+
+// Domain layouts under a PS_CFG_HOME
+var discoverLayouts = []struct {
+	domainType string
+	pattern    string // domain name is the directory matched by the first *
+}{
+	{"app", "appserv/*/psappsrv.cfg"},
+	{"prc", "appserv/prcs/*/psprcs.cfg"},
+	{"web", "webserv/*/config/config.xml"},
+}
+
+// Parts of a WebLogic config.xml used to build a web target
+type weblogicConfig struct {
+	DomainVersion   string `xml:"domain-version"`
+	AdminServerName string `xml:"admin-server-name"`
+	Servers         []struct {
+		Name       string `xml:"name"`
+		ListenPort string `xml:"listen-port"`
+	} `xml:"server"`
+}
+
+// Find the app server, process scheduler and PIA domains of this host in the
+// DiscoverCfgHomes directories.  App and prc JMX ports come from the
+// DiscoverJMXPortKey setting of psappsrv.cfg/psprcs.cfg, web ports from the
+// admin server of the WebLogic config.xml.  Domains without a JMX port are
+// skipped with a warning.
+func DiscoverDomains(config *JMXConfig) ([]*PsoftDomain, error) {
+	var lineErrors []InventoryLineError
+	addError := func(path string, format string, args ...interface{}) {
+		lineErrors = append(lineErrors, InventoryLineError{File: path, Err: fmt.Sprintf(format, args...)})
+	}
+	currHost, _ := os.Hostname()
+	domainSources := make(map[string]string)
+	domainList := []*PsoftDomain{}
+
+	for _, home := range config.DiscoverCfgHomes {
+		if info, err := os.Stat(home); err != nil || !info.IsDir() {
+			addError(home, "PS_CFG_HOME is not a directory")
+			continue
+		}
+		for _, layout := range discoverLayouts {
+			matches, _ := filepath.Glob(filepath.Join(home, layout.pattern))
+			sort.Strings(matches)
+			for _, path := range matches {
+				relPath, _ := filepath.Rel(home, path)
+				domain := &PsoftDomain{
+					DomainName:  discoveredDomainName(relPath, layout.pattern),
+					DomainType:  layout.domainType,
+					HostName:    currHost,
+					JMXUser:     config.DiscoverJMXUser,
+					JMXPassword: config.DiscoverJMXPassword,
+					source:      path,
+				}
+				var err error
+				if layout.domainType == "web" {
+					err = readWeblogicConfig(path, domain)
+				} else {
+					err = readTuxedoConfig(path, config.DiscoverJMXPortKey, domain)
+				}
+				if err != nil {
+					addError(path, "%s", err)
+					continue
+				}
+				if domain.JMXPort == "" {
+					srvlog.Warn("Skipping discovered domain " + domain.DomainName + ", no JMX port in " + path)
+					continue
+				}
+				for _, problem := range validateDomain(domain) {
+					addError(path, "%s", problem)
+				}
+				if firstSource, ok := domainSources[domain.DomainName]; ok {
+					addError(path, "duplicate DomainName %s, first found in %s", domain.DomainName, firstSource)
+					continue
+				}
+				domainSources[domain.DomainName] = path
+				domainList = append(domainList, domain)
+				srvlog.Debug("Discovered " + domain.DomainType + " domain " + domain.DomainName + " in " + path)
+			}
+		}
+	}
+	if len(lineErrors) > 0 {
+		return nil, &InventoryError{Errors: lineErrors}
+	}
+	return domainList, nil
+}
+
+// the path element matched by the first * of the layout pattern
+func discoveredDomainName(relPath string, pattern string) string {
+	pathParts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i, part := range strings.Split(pattern, "/") {
+		if part == "*" && i < len(pathParts) {
+			return pathParts[i]
+		}
+	}
+	return ""
+}
+
+// JMX port of an app server or process scheduler domain.  portKey is
+// "Section/Key", or only a key looked up in every section.
+func readTuxedoConfig(path string, portKey string, domain *PsoftDomain) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	wantSection := ""
+	wantKey := portKey
+	if i := strings.LastIndex(portKey, "/"); i >= 0 {
+		wantSection, wantKey = portKey[:i], portKey[i+1:]
+	}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 || !strings.EqualFold(strings.TrimSpace(keyValue[0]), wantKey) {
+			continue
+		}
+		if wantSection != "" && !strings.EqualFold(section, wantSection) {
+			continue
+		}
+		domain.JMXPort = strings.TrimSpace(keyValue[1])
+		break
+	}
+	return scanner.Err()
+}
+
+// Listen port and server name of the admin server of a PIA domain
+func readWeblogicConfig(path string, domain *PsoftDomain) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config weblogicConfig
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	domain.WeblogicVer = config.DomainVersion
+	for _, server := range config.Servers {
+		if server.Name != config.AdminServerName && len(config.Servers) > 1 {
+			continue
+		}
+		domain.ServerName = server.Name
+		domain.JMXPort = server.ListenPort
+		if domain.JMXPort == "" {
+			domain.JMXPort = weblogicDefaultListenPort
+		}
+		break
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package psoftjmx
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverDomains(t *testing.T) {
+	home := writeTestCfgHome(t, "HRDEV", "10100")
+	writeFile := func(relPath string, content string) {
+		path := filepath.Join(home, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Dir(path), filepath.Base(path), content)
+	}
+	// JMX port only in another section's key name
+	writeFile("appserv/prcs/PRCSDEV/psprcs.cfg", "[Process Scheduler]\nPrcs Name=PSUNX\n[JMX]\nrmi port = 10200\n")
+	// no JMX port, skipped
+	writeFile("appserv/NOJMX/psappsrv.cfg", "[Domain Settings]\nDomain ID=NOJMX\n")
+	writeFile("webserv/peoplesoft/config/config.xml", `<?xml version="1.0"?>
+<domain xmlns="http://xmlns.oracle.com/weblogic/domain">
+  <domain-version>12.2.1.4.0</domain-version>
+  <server><name>PIA</name><listen-port>8000</listen-port></server>
+  <server><name>PIA2</name><listen-port>8010</listen-port></server>
+  <admin-server-name>PIA</admin-server-name>
+</domain>`)
+	writeFile("webserv/single/config/config.xml", `<domain><server><name>PIA</name></server></domain>`)
+
+	config := &JMXConfig{DiscoverCfgHomes: []string{home}, DiscoverJMXPortKey: "RMI Port", DiscoverJMXUser: "system", DiscoverJMXPassword: "secret"}
+	domains, err := DiscoverDomains(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostName, _ := os.Hostname()
+	want := []PsoftDomain{
+		{DomainName: "HRDEV", DomainType: "app", JMXPort: "10100"},
+		{DomainName: "PRCSDEV", DomainType: "prc", JMXPort: "10200"},
+		{DomainName: "peoplesoft", DomainType: "web", JMXPort: "8000", ServerName: "PIA", WeblogicVer: "12.2.1.4.0"},
+		{DomainName: "single", DomainType: "web", JMXPort: "7001", ServerName: "PIA"}, // default listen-port
+	}
+	if len(domains) != len(want) {
+		t.Fatalf("got %d domains, want %d: %v", len(domains), len(want), domains)
+	}
+	for i, domain := range domains {
+		w := want[i]
+		if domain.DomainName != w.DomainName || domain.DomainType != w.DomainType || domain.JMXPort != w.JMXPort ||
+			domain.ServerName != w.ServerName || domain.WeblogicVer != w.WeblogicVer {
+			t.Errorf("domain %d = %+v, want %+v", i, *domain, w)
+		}
+		if domain.HostName != hostName || domain.JMXUser != "system" || domain.JMXPassword != "secret" {
+			t.Errorf("%s: HostName %s, JMXUser %s", domain.DomainName, domain.HostName, domain.JMXUser)
+		}
+	}
+
+	// a key in a named section only
+	config.DiscoverJMXPortKey = "JMX/RMI Port"
+	if domains, err := DiscoverDomains(config); err != nil || len(domains) != 3 {
+		t.Errorf("with a section: got %d domains and %v, want 3 without HRDEV", len(domains), err)
+	}
+
+	config.DiscoverCfgHomes = append(config.DiscoverCfgHomes, filepath.Join(home, "missing"))
+	var invErr *InventoryError
+	if _, err := DiscoverDomains(config); !errors.As(err, &invErr) {
+		t.Errorf("got %v, want an InventoryError for the missing PS_CFG_HOME", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Fields available to DomainNameTemplate and JMXDomainTemplate, ie
+// {{.DomainName}}{{suffix 2 .ShortHost}} or {{.App}}{{.Env}}_{{.ServerName}}
+type domainNameData struct {
+	PsoftDomain
+	Host      string // current host name
+	ShortHost string // current host name without the domain
+}
+
+var domainNameFuncs = template.FuncMap{
+	// last n characters, the whole string when shorter
+	"suffix": func(n int, s string) string {
+		if n < 0 || n >= len(s) {
+			return s
+		}
+		return s[len(s)-n:]
+	},
+	// first n characters, the whole string when shorter
+	"prefix": func(n int, s string) string {
+		if n < 0 || n >= len(s) {
+			return s
+		}
+		return s[:n]
+	},
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+}
+
+// Renames targets with the configured templates
+type domainNamer struct {
+	display *template.Template // reported DomainName
+	jmx     *template.Template // Tuxedo domain name in the JMX URL
+}
+
+// Templates from the config.  The legacy ConcatenateDomainWithHost settings are
+// converted to the same template for both names, as they always renamed both.
+func newDomainNamer(config *JMXConfig) (*domainNamer, error) {
+	displayTemplate := config.DomainNameTemplate
+	jmxTemplate := config.JMXDomainTemplate
+	if displayTemplate == "" && config.ConcatenateDomainWithHost {
+		if config.UseLastXCharactersOfHost > 0 {
+			displayTemplate = "{{.DomainName}}{{suffix " + strconv.Itoa(config.UseLastXCharactersOfHost) + " .ShortHost}}"
+		} else {
+			displayTemplate = "{{.DomainName}}{{.ShortHost}}"
+		}
+		if jmxTemplate == "" {
+			jmxTemplate = displayTemplate
+		}
+	}
+	namer := &domainNamer{}
+	var err error
+	if displayTemplate != "" {
+		namer.display, err = template.New("DomainNameTemplate").Funcs(domainNameFuncs).Option("missingkey=error").Parse(displayTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if jmxTemplate != "" {
+		namer.jmx, err = template.New("JMXDomainTemplate").Funcs(domainNameFuncs).Option("missingkey=error").Parse(jmxTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return namer, nil
+}
+
+// Set the display and JMX domain names of a target from its inventory fields
+func (namer *domainNamer) rename(domain *PsoftDomain, host string) error {
+	data := domainNameData{PsoftDomain: *domain, Host: host, ShortHost: shortName(host)}
+	domain.JMXDomain = domain.DomainName
+	if namer.jmx != nil {
+		var name bytes.Buffer
+		if err := namer.jmx.Execute(&name, data); err != nil {
+			return err
+		}
+		domain.JMXDomain = name.String()
+	}
+	if namer.display != nil {
+		var name bytes.Buffer
+		if err := namer.display.Execute(&name, data); err != nil {
+			return err
+		}
+		domain.DomainName = name.String()
+	}
+	return nil
+}
+
+// Reported names must stay unique after renaming, the scheduler results and
+// the breakers are keyed by them, ie {{.App}}{{.Env}} merges every domain of an env
+func checkUniqueNames(domainList []*PsoftDomain) error {
+	sources := make(map[string]string, len(domainList))
+	var duplicates []string
+	for _, domain := range domainList {
+		if source, ok := sources[domain.DomainName]; ok {
+			duplicates = append(duplicates, fmt.Sprintf("%s from %s and %s", domain.DomainName, source, domain.source))
+			continue
+		}
+		sources[domain.DomainName] = domain.source
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("Duplicate domain names after renaming, check DomainNameTemplate: %s", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package psoftjmx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a file in the test's temporary directory and return its path
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDomainNameTemplates(t *testing.T) {
+	namer, err := newDomainNamer(&JMXConfig{
+		DomainNameTemplate: "{{.DomainName}}{{suffix 2 .ShortHost}}",
+		JMXDomainTemplate:  "{{lower .DomainName}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := &PsoftDomain{DomainName: "HRPRD", HostName: "pshrapp01"}
+	if err := namer.rename(domain, "pshrapp01.example.edu"); err != nil {
+		t.Fatal(err)
+	}
+	if domain.DomainName != "HRPRD01" || domain.JMXDomain != "hrprd" {
+		t.Errorf("got DomainName %s and JMXDomain %s, want HRPRD01 and hrprd", domain.DomainName, domain.JMXDomain)
+	}
+	// shorter host than the suffix
+	if err := namer.rename(domain, "h"); err != nil || !strings.HasSuffix(domain.DomainName, "h") {
+		t.Errorf("got %s, %v", domain.DomainName, err)
+	}
+}
+
+func TestDomainNameTemplateDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	inventory := writeTestFile(t, dir, "inventory.txt",
+		"HRWEB1 web HR PRD prod PIA1 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA2 192.0.2.12 8.60 12.2 7001 system secret\n")
+	cli := &PsoftJmxClient{Config: &JMXConfig{PathInventoryFile: inventory, DomainNameTemplate: "{{.App}}{{.Env}}"}}
+	err := cli.LoadTargets()
+	if err == nil {
+		t.Fatal("want an error for the duplicate names")
+	}
+	for _, want := range []string{"HRPRD", inventory + ":1", inventory + ":2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't name %s", err, want)
+		}
+	}
+
+	cli.Config.DomainNameTemplate = "{{.App}}{{.Env}}_{{.ServerName}}"
+	if err := cli.LoadTargets(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cli.DomainList) != 2 || cli.DomainList[0].DomainName != "HRPRD_PIA1" {
+		t.Errorf("got %#v", cli.DomainList)
+	}
+}
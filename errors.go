@@ -0,0 +1,123 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Classes of JMX failures, test with errors.Is
+var (
+	ErrAuth               = errors.New("invalid JMX user/password")
+	ErrConnectRefused     = errors.New("JMX connection refused")
+	ErrConnectReset       = errors.New("JMX connection dropped")
+	ErrTimeout            = errors.New("JMX request timed out")
+	ErrNailgunUnavailable = errors.New("nailgun server unavailable")
+	ErrNailgunCommand     = errors.New("nailgun server has no JMXQuery command")
+	ErrCollectorIO        = errors.New("collector file I/O failed")
+	ErrParse              = errors.New("unable to parse JMX results")
+	ErrQueryFailed        = errors.New("JMX query failed")
+)
+
+const (
+	// JMXQuery/Nailgun exit codes
+	jmxExitAuthFailure  = 899
+	ngExitNoSuchCommand = 898
+	// keep the start of a java stack trace, the rest is rarely useful
+	maxStderrBytes = 4096
+)
+
+// Status values reported for targets that failed
+const (
+	statusDown           = "Down"
+	statusConfigError    = "Config Error"
+	statusCollectorError = "Collector Error"
+)
+
+// Failure of a JMX request for a single target
+type JMXError struct {
+	Domain   string // target domain name
+	ExitCode int    // JMXQuery exit code, 0 if the command never ran
+	Response string // stdout of the JMXQuery command
+	Stderr   string // stderr of the JMXQuery command, truncated to maxStderrBytes
+	Kind     error  // one of the Err* classes
+	Err      error  // underlying cause, if any
+}
+
+func (e *JMXError) Error() string {
+	msg := e.Kind.Error() + " for JMX target " + e.Domain
+	if e.ExitCode != 0 {
+		msg += fmt.Sprintf(", exitCode: %d", e.ExitCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Response != "" {
+		msg += " response: " + e.Response
+	}
+	if e.Stderr != "" {
+		// only the exception line, the full trace is kept in Stderr
+		msg += " stderr: " + strings.TrimSpace(strings.SplitN(e.Stderr, "\n", 2)[0])
+	}
+	return msg
+}
+
+// errors.Is matches the failure class
+func (e *JMXError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *JMXError) Unwrap() error {
+	return e.Err
+}
+
+// map a non-zero JMXQuery exit code and its output to a failure class
+func classifyExitCode(exitCode int, response string) error {
+	switch exitCode {
+	case jmxExitAuthFailure:
+		return ErrAuth
+	case ngExitNoSuchCommand:
+		return ErrNailgunCommand
+	}
+	lower := strings.ToLower(response)
+	switch {
+	case strings.Contains(lower, "connection refused") || strings.Contains(lower, "connectexception"):
+		return ErrConnectRefused
+	case strings.Contains(lower, "timed out") || strings.Contains(lower, "timeoutexception"):
+		return ErrTimeout
+	case strings.Contains(lower, "connection reset") || strings.Contains(lower, "broken pipe") ||
+		strings.Contains(lower, "connectioexception") || strings.Contains(lower, "eofexception"):
+		return ErrConnectReset
+	}
+	return ErrQueryFailed
+}
+
+// status reported for a failed target: bad config, broken collector or down domain
+func statusForError(err error) string {
+	switch {
+	case errors.Is(err, ErrAuth) || errors.Is(err, ErrParse):
+		return statusConfigError
+	case errors.Is(err, ErrNailgunUnavailable) || errors.Is(err, ErrNailgunCommand) || errors.Is(err, ErrCollectorIO):
+		return statusCollectorError
+	}
+	return statusDown
+}
+
+// cap the captured stderr so a runaway stack trace doesn't bloat every event
+func truncateStderr(stderr string) string {
+	if len(stderr) <= maxStderrBytes {
+		return stderr
+	}
+	return stderr[:maxStderrBytes] + "\n... (truncated)"
+}
+
+// stderr detail of a failed request, empty if there was none
+func errorDetail(err error) string {
+	var jmxErr *JMXError
+	if errors.As(err, &jmxErr) {
+		return jmxErr.Stderr
+	}
+	return ""
+}
@@ -0,0 +1,393 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// version 1: columns by position, optional header row
+	// version 2: header row required
+	inventoryVersionPositional = 1
+	inventoryVersionHeader     = 2
+)
+
+var (
+	// ie "#version: 2" or "# psoftjmx-inventory version=2"
+	inventoryVersionDirective = regexp.MustCompile(`^#\s*(?:psoftjmx-inventory\s+)?version\s*[:=]\s*(\S+)\s*$`)
+	validDomainTypes          = map[string]bool{"web": true, "app": true, "prc": true}
+	requiredInventoryColumns  = []string{"DomainName", "DomainType", "HostName", "JMXPort"}
+)
+
+type inventoryColumn struct {
+	name string
+	set  func(domain *PsoftDomain, value string) error
+}
+
+// Inventory columns, in the positional (version 1) order
+var inventoryColumns = []inventoryColumn{
+	{"DomainName", func(d *PsoftDomain, v string) error { d.DomainName = v; return nil }},
+	{"DomainType", func(d *PsoftDomain, v string) error { d.DomainType = v; return nil }},
+	{"App", func(d *PsoftDomain, v string) error { d.App = v; return nil }},
+	{"Env", func(d *PsoftDomain, v string) error { d.Env = v; return nil }},
+	{"Purpose", func(d *PsoftDomain, v string) error { d.Purpose = v; return nil }},
+	{"ServerName", func(d *PsoftDomain, v string) error { d.ServerName = v; return nil }},
+	{"HostName", func(d *PsoftDomain, v string) error { d.HostName = v; return nil }},
+	{"ToolsVer", func(d *PsoftDomain, v string) error { d.ToolsVer = v; return nil }},
+	{"WeblogicVer", func(d *PsoftDomain, v string) error { d.WeblogicVer = v; return nil }},
+	{"JMXPort", func(d *PsoftDomain, v string) error { d.JMXPort = v; return nil }},
+	{"JMXUser", func(d *PsoftDomain, v string) error { d.JMXUser = blankDash(v); return nil }},
+	{"JMXPassword", func(d *PsoftDomain, v string) error { d.JMXPassword = blankDash(v); return nil }},
+	{"Labels", func(d *PsoftDomain, v string) (err error) { d.Labels, err = ParseLabels(v); return err }},
+	{"AdminServer", func(d *PsoftDomain, v string) (err error) { d.AdminServer, err = parseYesNo(v); return err }},
+}
+
+// "-" leaves a column blank, ie for the credentials file to fill in
+func blankDash(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// Y/N style inventory flag, "-" or empty for no
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "y", "yes", "true", "1":
+		return true, nil
+	case "", "-", "n", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid value %q, expecting Y or N", value)
+}
+
+// Problem found on a single inventory line
+type InventoryLineError struct {
+	File string
+	Line int
+	Err  string
+}
+
+func (e InventoryLineError) Error() string {
+	if e.Line == 0 {
+		return e.File + ": " + e.Err
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+// Every problem found in an inventory file, the file is rejected as a whole
+type InventoryError struct {
+	Errors []InventoryLineError
+}
+
+func (e *InventoryError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, lineErr := range e.Errors {
+		msgs = append(msgs, lineErr.Error())
+	}
+	return "Invalid inventory: " + strings.Join(msgs, "; ")
+}
+
+func findInventoryColumn(name string) (inventoryColumn, bool) {
+	for _, column := range inventoryColumns {
+		if strings.EqualFold(column.name, name) {
+			return column, true
+		}
+	}
+	return inventoryColumn{}, false
+}
+
+// Parse and validate a space delimited inventory file.  Lines starting with #
+// are comments, except for a "#version: N" directive before the first target.
+// A first row starting with DomainName is a header naming the columns.
+func ReadInventory(path string) ([]*PsoftDomain, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	srvlog.Debug("Reading file ", path)
+
+	var lineErrors []InventoryLineError
+	addError := func(line int, format string, args ...interface{}) {
+		lineErrors = append(lineErrors, InventoryLineError{File: path, Line: line, Err: fmt.Sprintf(format, args...)})
+	}
+
+	version := inventoryVersionPositional
+	columns := inventoryColumns
+	seenHeader := false
+	seenTarget := false
+	domainLines := make(map[string]int)
+	domainList := []*PsoftDomain{}
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if match := inventoryVersionDirective.FindStringSubmatch(line); match != nil {
+				if seenHeader || seenTarget {
+					addError(lineNum, "version directive must come before the header and targets")
+					continue
+				}
+				version, err = strconv.Atoi(match[1])
+				if err != nil || version < inventoryVersionPositional || version > inventoryVersionHeader {
+					addError(lineNum, "unsupported inventory version %q", match[1])
+					version = inventoryVersionPositional
+				}
+			}
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.Comma = ' '
+		reader.FieldsPerRecord = -1
+		fields, err := reader.Read()
+		if err != nil {
+			addError(lineNum, "%s", err)
+			continue
+		}
+
+		if !seenHeader && !seenTarget && strings.EqualFold(fields[0], "DomainName") {
+			seenHeader = true
+			columns = nil
+			named := make(map[string]bool)
+			for _, name := range fields {
+				column, ok := findInventoryColumn(name)
+				if !ok {
+					addError(lineNum, "unknown column %q", name)
+					continue
+				}
+				if named[column.name] {
+					addError(lineNum, "duplicate column %q", name)
+				}
+				named[column.name] = true
+				columns = append(columns, column)
+			}
+			for _, name := range requiredInventoryColumns {
+				if !named[name] {
+					addError(lineNum, "missing required column %q", name)
+				}
+			}
+			continue
+		}
+		if !seenTarget && !seenHeader && version == inventoryVersionHeader {
+			addError(lineNum, "inventory version %d requires a header row", version)
+		}
+		seenTarget = true
+
+		if len(fields) > len(columns) || (seenHeader && len(fields) != len(columns)) {
+			addError(lineNum, "expected %d columns, found %d", len(columns), len(fields))
+			continue
+		}
+		domain := &PsoftDomain{}
+		for i, value := range fields {
+			if err := columns[i].set(domain, value); err != nil {
+				addError(lineNum, "column %s: %s", columns[i].name, err)
+			}
+		}
+		if domain.DomainName == "" {
+			addError(lineNum, "missing DomainName")
+			continue
+		}
+		for _, problem := range validateDomain(domain) {
+			addError(lineNum, "%s", problem)
+		}
+		if firstLine, ok := domainLines[domain.DomainName]; ok {
+			addError(lineNum, "duplicate DomainName %s, first defined on line %d", domain.DomainName, firstLine)
+			continue
+		}
+		domainLines[domain.DomainName] = lineNum
+		domain.source = fmt.Sprintf("%s:%d", path, lineNum)
+		domainList = append(domainList, domain)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lineErrors) > 0 {
+		return nil, &InventoryError{Errors: lineErrors}
+	}
+	return domainList, nil
+}
+
+// Problems with a target's fields, whatever the inventory source
+func validateDomain(domain *PsoftDomain) []string {
+	var problems []string
+	if !validDomainTypes[domain.DomainType] {
+		problems = append(problems, fmt.Sprintf("unknown DomainType %q for %s, expecting web, app or prc", domain.DomainType, domain.DomainName))
+	}
+	if domain.HostName == "" {
+		problems = append(problems, fmt.Sprintf("missing HostName for %s", domain.DomainName))
+	}
+	if port, err := strconv.Atoi(domain.JMXPort); err != nil || port <= 0 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("invalid JMXPort %q for %s", domain.JMXPort, domain.DomainName))
+	}
+	if domain.AdminServer && domain.DomainType != "web" {
+		problems = append(problems, fmt.Sprintf("AdminServer is only valid for web targets, %s is %s", domain.DomainName, domain.DomainType))
+	}
+	return problems
+}
+
+// Inventory files and patterns in merge order: PathInventoryFile, the
+// deprecated DomainInventoryFile, then each InventoryFiles entry
+func (config *JMXConfig) inventoryPatterns() []string {
+	patterns := []string{}
+	for _, pattern := range append([]string{config.PathInventoryFile, config.DomainInventoryFile}, config.InventoryFiles...) {
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// Expand inventory patterns to files.  A directory stands for every file in
+// it, matches of a glob or directory are read in name order.  A plain file
+// must exist, a glob or directory matching nothing is fine (ie empty conf.d).
+func expandInventoryPatterns(patterns []string) ([]string, error) {
+	files := []string{}
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches := []string{pattern}
+		if isGlobPattern(pattern) {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid inventory pattern %s: %s", pattern, err)
+			}
+			sort.Strings(matches)
+			// skip hidden files, editor backups and sub directories
+			kept := matches[:0]
+			for _, match := range matches {
+				base := filepath.Base(match)
+				if strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") {
+					continue
+				}
+				if info, err := os.Stat(match); err == nil && info.IsDir() {
+					continue
+				}
+				kept = append(kept, match)
+			}
+			matches = kept
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Read one inventory file, .yml and .yaml files are Ansible inventories
+func (config *JMXConfig) readInventoryFile(path string) ([]*PsoftDomain, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return ReadAnsibleInventory(path, config.AnsibleDomainsVar, config.AnsibleVars)
+	}
+	return ReadInventory(path)
+}
+
+// Read and merge inventory files in order, after the domains discovered in
+// DiscoverCfgHomes.  A target defined again in a later file replaces the
+// earlier definition with a warning naming both, or rejects the inventory when
+// RejectDuplicateDomains is set.  Files override discovered domains quietly.
+// Every file is validated and all problems are reported together.
+func ReadInventories(config *JMXConfig, paths []string) ([]*PsoftDomain, error) {
+	return mergeInventories(config, nil, paths)
+}
+
+// ReadInventories with the targets of the SQL inventory merged before the files
+func mergeInventories(config *JMXConfig, sqlList []*PsoftDomain, paths []string) ([]*PsoftDomain, error) {
+	var lineErrors []InventoryLineError
+	domainIndex := make(map[string]int)
+	domainList := []*PsoftDomain{}
+	discovered := make(map[string]bool)
+	if len(config.DiscoverCfgHomes) > 0 {
+		discoveredList, err := DiscoverDomains(config)
+		if invErr, ok := err.(*InventoryError); ok {
+			lineErrors = append(lineErrors, invErr.Errors...)
+		} else if err != nil {
+			return nil, err
+		}
+		for _, domain := range discoveredList {
+			discovered[domain.DomainName] = true
+			domainIndex[domain.DomainName] = len(domainList)
+			domainList = append(domainList, domain)
+		}
+	}
+	merge := func(sourceList []*PsoftDomain) {
+		for _, domain := range sourceList {
+			i, ok := domainIndex[domain.DomainName]
+			if !ok {
+				domainIndex[domain.DomainName] = len(domainList)
+				domainList = append(domainList, domain)
+				continue
+			}
+			if discovered[domain.DomainName] {
+				srvlog.Debug("Inventory target "+domain.DomainName+" overrides the discovered domain",
+					"discovered", domainList[i].source, "source", domain.source)
+				discovered[domain.DomainName] = false
+				domainList[i] = domain
+				continue
+			}
+			if config.RejectDuplicateDomains {
+				file, line := splitSource(domain.source)
+				lineErrors = append(lineErrors, InventoryLineError{File: file, Line: line,
+					Err: fmt.Sprintf("duplicate DomainName %s, first defined in %s", domain.DomainName, domainList[i].source)})
+				continue
+			}
+			srvlog.Warn("Inventory target "+domain.DomainName+" redefined, later definition wins",
+				"previous", domainList[i].source, "source", domain.source)
+			domainList[i] = domain
+		}
+	}
+	merge(sqlList)
+	for _, path := range paths {
+		fileList, err := config.readInventoryFile(path)
+		if invErr, ok := err.(*InventoryError); ok {
+			lineErrors = append(lineErrors, invErr.Errors...)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		merge(fileList)
+	}
+	if len(lineErrors) > 0 {
+		return nil, &InventoryError{Errors: lineErrors}
+	}
+	return domainList, nil
+}
+
+// file and line of a "file:line" source, the whole source when there is no line
+func splitSource(source string) (string, int) {
+	i := strings.LastIndex(source, ":")
+	if i < 0 {
+		return source, 0
+	}
+	line, err := strconv.Atoi(source[i+1:])
+	if err != nil {
+		return source, 0
+	}
+	return source[:i], line
+}
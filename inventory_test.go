@@ -0,0 +1,159 @@
+package psoftjmx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInventory(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "inventory.txt", `#version: 2
+# comment
+DomainName DomainType App Env HostName JMXPort Labels
+HRWEB1 web HR PRD 192.0.2.11 7001 team=hcm,dc=east
+HRAPP1 app HR PRD 192.0.2.12 12000 -
+`)
+	domains, err := ReadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("got %d targets, want 2", len(domains))
+	}
+	if domains[0].DomainName != "HRWEB1" || domains[0].JMXPort != "7001" || domains[0].Labels["team"] != "hcm" {
+		t.Errorf("got %#v", domains[0])
+	}
+	if domains[1].source != path+":5" {
+		t.Errorf("source = %s, want %s:5", domains[1].source, path)
+	}
+
+	// positional columns, no header
+	path = writeTestFile(t, dir, "positional.txt", "HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	if domains, err = ReadInventory(path); err != nil || len(domains) != 1 || domains[0].JMXPassword != "secret" {
+		t.Errorf("got %#v, %v", domains, err)
+	}
+}
+
+func TestReadInventoryErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "inventory.txt", `DomainName DomainType HostName JMXPort Bogus
+HRWEB1 web 192.0.2.11 7001
+`)
+	_, err := ReadInventory(path)
+	var inventoryErr *InventoryError
+	if !errors.As(err, &inventoryErr) {
+		t.Fatalf("got %v, want an *InventoryError", err)
+	}
+	if len(inventoryErr.Errors) != 1 || inventoryErr.Errors[0].Line != 1 || !strings.Contains(inventoryErr.Errors[0].Err, `unknown column "Bogus"`) {
+		t.Errorf("got %v", inventoryErr.Errors)
+	}
+
+	path = writeTestFile(t, dir, "targets.txt", `#version: 2
+DomainName DomainType HostName JMXPort
+HRWEB1 web 192.0.2.11 7001
+HRWEB2 tux 192.0.2.12 7001
+HRWEB3 web 192.0.2.13 port
+HRWEB1 web 192.0.2.14 7001
+HRWEB4 web 192.0.2.15
+#version: 1
+`)
+	_, err = ReadInventory(path)
+	if !errors.As(err, &inventoryErr) {
+		t.Fatalf("got %v, want an *InventoryError", err)
+	}
+	want := []struct {
+		line int
+		text string
+	}{
+		{4, `unknown DomainType "tux"`},
+		{5, `invalid JMXPort "port"`},
+		{6, "duplicate DomainName HRWEB1, first defined on line 3"},
+		{7, "expected 4 columns, found 3"},
+		{8, "version directive must come before"},
+	}
+	if len(inventoryErr.Errors) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(inventoryErr.Errors), len(want), err)
+	}
+	for i, w := range want {
+		got := inventoryErr.Errors[i]
+		if got.File != path || got.Line != w.line || !strings.Contains(got.Err, w.text) {
+			t.Errorf("error %d = %s, want line %d %q", i, got, w.line, w.text)
+		}
+	}
+
+	path = writeTestFile(t, dir, "noheader.txt", "#version: 2\nHRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	if _, err = ReadInventory(path); err == nil || !strings.Contains(err.Error(), "requires a header row") {
+		t.Errorf("got %v, want a missing header error", err)
+	}
+}
+
+// PS_CFG_HOME with an app server domain listening for JMX on port
+func writeTestCfgHome(t *testing.T, domainName string, port string) string {
+	t.Helper()
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "appserv", domainName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(home, "appserv", domainName), "psappsrv.cfg", "[Domain Settings]\nDomain ID="+domainName+"\n\n[JMX Settings]\nRMI Port="+port+"\n")
+	return home
+}
+
+func TestMergeInventories(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTestFile(t, dir, "first.txt",
+		"HRAPP1 app HR PRD prod APPDOM 192.0.2.11 8.60 12.2 9001 system secret\n"+
+			"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	second := writeTestFile(t, dir, "second.txt",
+		"HRWEB1 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n")
+	sqlList := []*PsoftDomain{
+		{DomainName: "HRAPP1", DomainType: "app", HostName: "192.0.2.13", JMXPort: "9001", source: "sql inventory:1"},
+		{DomainName: "HRAPP2", DomainType: "app", HostName: "192.0.2.13", JMXPort: "9011", source: "sql inventory:2"},
+	}
+	config := &JMXConfig{DiscoverCfgHomes: []string{writeTestCfgHome(t, "HRAPP2", "9099")}}
+
+	// discovered, then SQL, then the files in order, the later definition wins
+	domains, err := mergeInventories(config, copyDomains(sqlList), []string{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*PsoftDomain)
+	for _, domain := range domains {
+		byName[domain.DomainName] = domain
+	}
+	if len(domains) != 3 {
+		t.Fatalf("got %d targets, want 3", len(domains))
+	}
+	if domain := byName["HRAPP2"]; domain.JMXPort != "9011" || domain.source != "sql inventory:2" {
+		t.Errorf("HRAPP2 = %s from %s, want the SQL row over the discovered domain", domain.JMXPort, domain.source)
+	}
+	if domain := byName["HRAPP1"]; domain.source != first+":1" {
+		t.Errorf("HRAPP1 from %s, want the file over the SQL row", domain.source)
+	}
+	if domain := byName["HRWEB1"]; domain.HostName != "192.0.2.12" || domain.source != second+":1" {
+		t.Errorf("HRWEB1 from %s, want the second file", domain.source)
+	}
+
+	// rejected duplicates, an inventory entry replacing a discovered domain is fine
+	config.RejectDuplicateDomains = true
+	_, err = mergeInventories(config, copyDomains(sqlList), []string{first, second})
+	var invErr *InventoryError
+	if !errors.As(err, &invErr) {
+		t.Fatalf("got %v, want an InventoryError", err)
+	}
+	want := []string{
+		first + ":1: duplicate DomainName HRAPP1, first defined in sql inventory:1",
+		second + ":1: duplicate DomainName HRWEB1, first defined in " + first + ":2",
+	}
+	if len(invErr.Errors) != len(want) {
+		t.Fatalf("got %v, want %d errors", err, len(want))
+	}
+	for i, lineErr := range invErr.Errors {
+		if got := fmt.Sprintf("%s:%d: %s", lineErr.File, lineErr.Line, lineErr.Err); got != want[i] {
+			t.Errorf("error %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/UMN-PeopleSoft/nailgo"
+	"io/ioutil"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -22,9 +24,37 @@ type JMXConnection struct {
 	ConnectURL string
 	UserID     string
 	Password   string
+	CredFile   bool // pass the user/password in a one-time -credfile instead of -u/-p
+}
+
+// keep the password out of debug logs
+func (jmxConn JMXConnection) GoString() string {
+	return fmt.Sprintf("psoftjmx.JMXConnection{NGAddress:%q, ConnectURL:%q, UserID:%q, Password:\"****\", CredFile:%t}",
+		jmxConn.NGAddress, jmxConn.ConnectURL, jmxConn.UserID, jmxConn.CredFile)
+}
+
+// Write the credentials to a one-time file only readable by this user, so they are
+// never on the nailgun command line.  JMXQuery reads the user from the first line,
+// the password from the second, and removes the file.  The path is local, so the
+// Nailgun server must run on this host.
+func writeCredentialFile(userID string, password string) (string, error) {
+	credFile, err := ioutil.TempFile(runDir, "jmxcred-")
+	if err != nil {
+		return "", err
+	}
+	defer credFile.Close()
+	if err = credFile.Chmod(0600); err == nil {
+		_, err = credFile.WriteString(userID + "\n" + password + "\n")
+	}
+	if err != nil {
+		os.Remove(credFile.Name())
+		return "", err
+	}
+	return credFile.Name(), nil
 }
 
 // Initiates the JMX Command throught the NailGun Client call
+// Failures are returned as a *JMXError, test the class with errors.Is
 func (jmxConn *JMXConnection) RunJMXCommand(domainName string, attrList []string) (rawResponse string, err error) {
 	rawResponse = ""
 	ngBuf := new(bytes.Buffer)
@@ -32,7 +62,7 @@ func (jmxConn *JMXConnection) RunJMXCommand(domainName string, attrList []string
 	ngConn := &nailgo.NailgunConnection{}
 	ngConn.Conn, err = jmxConn.GetNGConn()
 	if err != nil {
-		return rawResponse, err
+		return rawResponse, &JMXError{Domain: domainName, Kind: ErrNailgunUnavailable, Err: err}
 	}
 	ngConn.Output = ngBuf
 	ngConn.Outerr = ngBufErr
@@ -42,48 +72,81 @@ func (jmxConn *JMXConnection) RunJMXCommand(domainName string, attrList []string
 	ngCmdArgs = append(ngCmdArgs, jmxConn.ConnectURL)
 	ngCmdArgs = append(ngCmdArgs, "-q")
 	ngCmdArgs = append(ngCmdArgs, strings.Join(attrList, ";"))
-	ngCmdArgs = append(ngCmdArgs, "-u")
-	ngCmdArgs = append(ngCmdArgs, jmxConn.UserID)
-	ngCmdArgs = append(ngCmdArgs, "-p")
-	ngCmdArgs = append(ngCmdArgs, jmxConn.Password)
+	// the debug log never gets the password
+	var logArgs []string
+	if jmxConn.CredFile {
+		// JMXQuery normally removes the file once read, make sure it never outlives the call
+		credFile, err := writeCredentialFile(jmxConn.UserID, jmxConn.Password)
+		if err != nil {
+			ngConn.Conn.Close()
+			return rawResponse, &JMXError{Domain: domainName, Kind: ErrCollectorIO, Err: err}
+		}
+		defer os.Remove(credFile)
+		ngCmdArgs = append(ngCmdArgs, "-credfile", credFile)
+		logArgs = ngCmdArgs
+	} else {
+		logArgs = append(append([]string{}, ngCmdArgs...), "-u", jmxConn.UserID, "-p", "****")
+		ngCmdArgs = append(ngCmdArgs, "-u", jmxConn.UserID, "-p", jmxConn.Password)
+	}
 
-	srvlog.Debug("JMX Conn: ngConn.SendCommand: " + fmt.Sprintf("%#v", ngCmdArgs))
+	srvlog.Debug("JMX Conn: ngConn.SendCommand: " + fmt.Sprintf("%#v", logArgs))
 	exitCode, err := ngConn.SendCommand(jmxClass, ngCmdArgs)
+	stderr := truncateStderr(ngBufErr.String())
+	if err != nil {
+		srvlog.Error("JMX ngConn.SendCommand failed for " + domainName + ": " + err.Error() + " stderr: " + stderr)
+		return "", &JMXError{Domain: domainName, ExitCode: exitCode, Stderr: stderr, Kind: ErrNailgunUnavailable, Err: err}
+	}
 	if exitCode != 0 {
-		srvlog.Error("JMX ngConn.SendCommand error for " + domainName + ": " + strconv.Itoa(exitCode) + ":  response: " + ngBuf.String())
-		if exitCode == 899 {
-			return "", fmt.Errorf("Invalid user/password to access JMX target %s", domainName)
-		} else {
-			return "", fmt.Errorf("Unable to connect to JMX target %s, exitCode: %d response: %s", domainName, exitCode, ngBuf.String())
+		srvlog.Error("JMX ngConn.SendCommand error for " + domainName + ": " + strconv.Itoa(exitCode) + ":  response: " + ngBuf.String() + " stderr: " + stderr)
+		return "", &JMXError{
+			Domain:   domainName,
+			ExitCode: exitCode,
+			Response: ngBuf.String(),
+			Stderr:   stderr,
+			// the exception text on stderr usually tells why the connection failed
+			Kind: classifyExitCode(exitCode, ngBuf.String()+stderr),
 		}
 	}
+	if stderr != "" {
+		srvlog.Warn("JMX Conn: stderr output for " + domainName + ": " + stderr)
+	}
 	srvlog.Debug("JMX Conn: ngConn.SendCommand: Completed sendcommand for " + domainName)
 	rawResponse = ngBuf.String()
 	return rawResponse, nil
 }
 
+// Raw ng-stats report from the Nailgun server
 func (jmxConn *JMXConnection) getNailGunStats() (rawResponse string, err error) {
 	rawResponse = ""
 	ngBuf := new(bytes.Buffer)
 	ngConn := &nailgo.NailgunConnection{}
 	ngConn.Conn, err = jmxConn.GetNGConn()
 	if err != nil {
-		return rawResponse, err
+		return rawResponse, &JMXError{Domain: "nailgun", Kind: ErrNailgunUnavailable, Err: err}
 	}
 	ngConn.Output = ngBuf
 
 	exitCode, err2 := ngConn.SendCommand("ng-stats", []string{})
 	if err2 != nil {
-		return rawResponse, err2
+		return rawResponse, &JMXError{Domain: "nailgun", Kind: ErrNailgunUnavailable, Err: err2}
 	}
 	if exitCode != 0 {
-		return rawResponse, errors.New("Unable to get Nailgun Stats")
+		return rawResponse, &JMXError{Domain: "nailgun", ExitCode: exitCode, Kind: ErrNailgunUnavailable, Err: errors.New("Unable to get Nailgun Stats")}
 	}
 	rawResponse = ngBuf.String()
 	return rawResponse, nil
 
 }
 
+// Parsed ng-stats report from the Nailgun server
+func (jmxConn *JMXConnection) GetNailGunStats() (*NailGunStats, error) {
+	rawResponse, err := jmxConn.getNailGunStats()
+	if err != nil {
+		return nil, err
+	}
+	return ParseNailGunStats(rawResponse)
+}
+
 func (jmxConn *JMXConnection) GetNGConn() (net.Conn, error) {
 
 	var err error
@@ -4,6 +4,7 @@ package psoftjmx
 
 import (
 	"sync"
+	"time"
 	//"fmt"
 )
 
@@ -11,26 +12,131 @@ import (
 type JMXResponse struct {
 	JMXQueryRequest JMXQueryRequest
 	MetricResults   map[string]interface{}
+	Duration        time.Duration // time the worker spent on the request
+	Skipped         bool          // not queried: in blackout, excluded or suppressed
+}
+
+// Tracks requests in flight per host, shared by every pool of a client so
+// overlapping pools can't overload a host together
+type hostLimiter struct {
+	max      int // max concurrent requests to a single host, 0 for no limit
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inFlight map[string]int
+}
+
+func newHostLimiter(max int) *hostLimiter {
+	limiter := &hostLimiter{max: max, inFlight: make(map[string]int)}
+	limiter.cond = sync.NewCond(&limiter.mu)
+	return limiter
+}
+
+// must hold limiter.mu
+func (l *hostLimiter) available(host string) bool {
+	return l.max <= 0 || l.inFlight[host] < l.max
+}
+
+func (l *hostLimiter) release(host string) {
+	l.mu.Lock()
+	l.inFlight[host]--
+	if l.inFlight[host] <= 0 {
+		delete(l.inFlight, host)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
 }
 
 // structure to manage concurrent JMX queries to a pool of workers
 type PoolManager struct {
 	NumWorkers int
 	NumJobs    int
-	jobs       chan JMXQueryRequest
+	limiter    *hostLimiter
+	slots      chan struct{}                // worker limit shared by overlapping pools, nil for NumWorkers only
+	hostQueues map[string][]JMXQueryRequest // pending jobs by host, guarded by limiter.mu
+	hostOrder  []string                     // round robin order of hosts
+	nextHost   int
+	pending    int
 	results    chan JMXResponse
+	busyMu     sync.Mutex
+	busyTime   time.Duration // total time workers spent processing jobs
+}
+
+// host a request connects to, used for the per host limit
+func requestHost(job JMXQueryRequest) string {
+	return job.Target.connectHost()
 }
 
 // Worker to process the JMX Request for each job/target.
 func (p *PoolManager) jmxWorker(wg *sync.WaitGroup) {
-	for job := range p.jobs {
+	for {
+		job, ok := p.nextJob()
+		if !ok {
+			break
+		}
+		// wait for a worker slot of the client, once the host has room
+		if p.slots != nil {
+			p.slots <- struct{}{}
+		}
 		//srvlog.Info("Calling job.SendJMXRequest()") // with: " +  fmt.Sprintf("%#v", job))
-		output := JMXResponse{job, job.SendJMXRequest()}
+		start := time.Now()
+		metrics, skipped := job.sendJMXRequest()
+		output := JMXResponse{job, metrics, time.Since(start), skipped}
+		p.limiter.release(requestHost(job))
+		p.releaseSlot()
+		p.busyMu.Lock()
+		p.busyTime += output.Duration
+		p.busyMu.Unlock()
 		p.results <- output
 	}
 	wg.Done()
 }
 
+func (p *PoolManager) releaseSlot() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+// Pick the next job round robin across hosts, skipping hosts at their limit.
+// Blocks until a host frees up, returns false once all jobs are taken.
+func (p *PoolManager) nextJob() (JMXQueryRequest, bool) {
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	for p.pending > 0 {
+		for i := 0; i < len(p.hostOrder); i++ {
+			index := (p.nextHost + i) % len(p.hostOrder)
+			host := p.hostOrder[index]
+			if len(p.hostQueues[host]) == 0 || !p.limiter.available(host) {
+				continue
+			}
+			job := p.hostQueues[host][0]
+			p.hostQueues[host] = p.hostQueues[host][1:]
+			p.limiter.inFlight[host]++
+			p.pending--
+			p.nextHost = index + 1
+			if p.pending == 0 {
+				// wake idle workers so they can exit
+				p.limiter.cond.Broadcast()
+			}
+			return job, true
+		}
+		p.limiter.cond.Wait()
+	}
+	return JMXQueryRequest{}, false
+}
+
+// Total time the workers spent processing jobs
+func (p *PoolManager) BusyTime() time.Duration {
+	p.busyMu.Lock()
+	defer p.busyMu.Unlock()
+	return p.busyTime
+}
+
+// Limit the number of concurrent requests to a single host, 0 for no limit
+func (p *PoolManager) LimitPerHost(max int) {
+	p.limiter = newHostLimiter(max)
+}
+
 // Setup the worker pools up to max number of workers
 func (p *PoolManager) createJMXWorkerPool() {
 	var wg sync.WaitGroup
@@ -42,12 +148,18 @@ func (p *PoolManager) createJMXWorkerPool() {
 	close(p.results)
 }
 
+// Queue the jobs by host, keeping the request order within each host
 func (p *PoolManager) loadJMXRequests(jmxJobs []JMXQueryRequest) {
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
 	for i := 0; i < len(jmxJobs); i++ {
-		p.jobs <- jmxJobs[i]
+		host := requestHost(jmxJobs[i])
+		if _, ok := p.hostQueues[host]; !ok {
+			p.hostOrder = append(p.hostOrder, host)
+		}
+		p.hostQueues[host] = append(p.hostQueues[host], jmxJobs[i])
+		p.pending++
 	}
-	close(p.jobs)
-
 }
 
 // Aggregate the metrics for all targets as they complete
@@ -65,7 +177,7 @@ func (p *PoolManager) waitForJMXResponse(metricDataChan chan []JMXResponse) {
 func (p *PoolManager) RunJobs(jmxJobs []JMXQueryRequest) []JMXResponse {
 
 	metricDataChan := make(chan []JMXResponse)
-	go p.loadJMXRequests(jmxJobs)
+	p.loadJMXRequests(jmxJobs)
 	go p.waitForJMXResponse(metricDataChan)
 	// start workers
 	srvlog.Debug("JMX Pool: Starting worker pool")
@@ -80,7 +192,8 @@ func (p *PoolManager) RunJobs(jmxJobs []JMXQueryRequest) []JMXResponse {
 func NewPoolManager(noOfJobs int, noOfWorkers int) *PoolManager {
 
 	poolManager := &PoolManager{NumWorkers: noOfWorkers, NumJobs: noOfJobs}
-	poolManager.jobs = make(chan JMXQueryRequest)
+	poolManager.limiter = newHostLimiter(0)
+	poolManager.hostQueues = make(map[string][]JMXQueryRequest)
 	poolManager.results = make(chan JMXResponse)
 	return poolManager
 
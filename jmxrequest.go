@@ -4,7 +4,7 @@ package psoftjmx
 
 import (
 	"fmt"
-	"strings"
+	"time"
 )
 
 // Thread request payload of query attributes to search for and target domains
@@ -14,6 +14,9 @@ type JMXQueryRequest struct {
 	MetricsCfg Metrics
 	Target     PsoftDomain
 	NGAddress  string
+	CredFile   bool // JMXConfig.JMXCredentialFile
+	Retry      RetryPolicy
+	Breakers   *domainBreakers      // skip domains that keep failing
 	Blackouts  []*BlackoutType      // list of domains or envs in a blackout
 	Excludes   []*ExcludeDomainType // exiting domains to skip for monitoring, ie only used for peak loads
 
@@ -27,12 +30,9 @@ const (
 )
 
 func (j *JMXQueryRequest) inBlackout(target PsoftDomain) bool {
+	now := time.Now()
 	for _, blackout := range j.Blackouts {
-		appenv := ""
-		if strings.Contains(blackout.DomainEnv, "ENV") {
-			appenv = blackout.DomainEnv[strings.LastIndex(blackout.DomainEnv, "ENV"):]
-		}
-		if blackout.DomainEnv == target.DomainName || appenv == target.App+target.Env {
+		if blackout.active(now) && blackout.matches(&target) {
 			return true
 		}
 	}
@@ -40,80 +40,101 @@ func (j *JMXQueryRequest) inBlackout(target PsoftDomain) bool {
 }
 
 func (j *JMXQueryRequest) isExcluded(target PsoftDomain) bool {
+	now := time.Now()
 	for _, exclude := range j.Excludes {
-		if exclude.DomainName == target.DomainName {
+		if !exclude.Expired(now) && exclude.DomainName == target.DomainName {
 			return true
 		}
 	}
 	return false
 }
 
+// JMX service URL of the target, the domain runtime MBean server for web targets
+func (d *PsoftDomain) jmxURL() string {
+	if d.DomainType == "web" {
+		return jmxWebURLPrefix +
+			d.connectHost() +
+			":" +
+			d.JMXPort +
+			jmxWebURLPath
+	}
+	return jmxTuxedoURLPrefix +
+		d.connectHost() +
+		"/jndi/rmi://" +
+		d.connectHost() +
+		":" +
+		d.JMXPort +
+		"/" +
+		d.jmxDomain() +
+		jmxTuxedoURLPath
+}
+
 // Main entry point for each threaded request to get metrics for a target
 func (j *JMXQueryRequest) SendJMXRequest() map[string]interface{} {
-	var url string
-	var mappedResults map[string]interface{}
+	mappedResults, _ := j.sendJMXRequest()
+	return mappedResults
+}
+
+// Metrics of the target, skipped is true when the target wasn't queried
+// because it is in blackout, excluded or suppressed by its breaker
+func (j *JMXQueryRequest) sendJMXRequest() (mappedResults map[string]interface{}, skipped bool) {
+	start := time.Now()
 
 	// Check if the target is in blackout or excluded list, skip if so, but always return metric map
 	if j.inBlackout(j.Target) {
 		mappedResults = make(map[string]interface{})
 		mappedResults["Status"] = "Blackout" // blackout
+		skipped = true
 	} else if j.isExcluded(j.Target) {
 		mappedResults = make(map[string]interface{})
 		mappedResults["Status"] = "Excluded" // excluded
+		skipped = true
+	} else if cached := j.Breakers.suppressed(j.Target.DomainName, start); cached != nil {
+		// still down, don't spend a worker on it until the next probe
+		mappedResults = cached
+		skipped = true
 	} else {
 		// Good to get metrics
-		if j.Target.DomainType == "web" {
-			url = jmxWebURLPrefix +
-				j.Target.HostName +
-				":" +
-				j.Target.JMXPort +
-				jmxWebURLPath
-		} else {
-			url = jmxTuxedoURLPrefix +
-				j.Target.HostName +
-				"/jndi/rmi://" +
-				j.Target.HostName +
-				":" +
-				j.Target.JMXPort +
-				"/" +
-				j.Target.DomainName +
-				jmxTuxedoURLPath
-		}
-
 		conn := &JMXConnection{
 			NGAddress:  j.NGAddress,
-			ConnectURL: url,
+			ConnectURL: j.Target.jmxURL(),
 			UserID:     j.Target.JMXUser,
 			Password:   j.Target.JMXPassword,
+			CredFile:   j.CredFile,
 		}
 
 		srvlog.Debug("JMX Request: SendJMXRequest for " + j.Target.DomainName + ": " + fmt.Sprintf("%#v", conn))
 
 		// Make the JMX Query Call, return just the raw string
-		jmxResponse, err := conn.RunJMXCommand(j.Target.DomainName, j.QueryList)
+		jmxResponse, attempts, err := j.Retry.run(j.Target.DomainName, func() (string, error) {
+			return conn.RunJMXCommand(j.Target.DomainName, j.QueryList)
+		})
 		if err != nil {
 			srvlog.Error("JMX Request: RunJMXCommand Error response for " + j.Target.DomainName + " : " + jmxResponse + " error: " + err.Error())
 			mappedResults = make(map[string]interface{})
 			mappedResults["errorMsg"] = err.Error()
-			if strings.Contains(err.Error(), "password") {
-				mappedResults["status"] = "Config Error"
-			} else {
-				mappedResults["status"] = "Down"
+			if detail := errorDetail(err); detail != "" {
+				mappedResults["errorDetail"] = detail
 			}
+			mappedResults["status"] = statusForError(err)
 		} else {
 			srvlog.Debug("JMX Request: RunJMXCommand response : " + jmxResponse)
 			// Convert the results only if there are valid results
 			mappedResults, err = j.MetricsCfg.MapData(j.Target.DomainType, jmxResponse)
 			if err != nil {
 				srvlog.Info("Failed to run MapData for %s: %s\n", j.Target.DomainName, err)
+				err = &JMXError{Domain: j.Target.DomainName, Kind: ErrParse, Err: err}
 				mappedResults = make(map[string]interface{})
 				mappedResults["errorMsg"] = err.Error()
-				mappedResults["status"] = "Config Error" // config error
+				mappedResults["status"] = statusForError(err) // config error
 			} else {
 				// valid target, valid results and map
 				mappedResults["status"] = "Up" //up
 			}
 		}
+		mappedResults["attempts"] = attempts
+		errorMsg, _ := mappedResults["errorMsg"].(string)
+		j.Breakers.record(j.Target.DomainName, mappedResults["status"].(string), errorMsg, time.Now())
 	}
 	// always add target attribues to the metric data to tie metrics (or errors) to a unique target
 	mappedResults["domain_name"] = j.Target.DomainName
@@ -124,8 +145,16 @@ func (j *JMXQueryRequest) SendJMXRequest() map[string]interface{} {
 	mappedResults["appenv"] = j.Target.App + j.Target.Env
 	mappedResults["serverName"] = j.Target.ServerName
 	mappedResults["host"] = j.Target.HostName
+	mappedResults["host_fqdn"] = j.Target.HostFQDN
+	mappedResults["connect_host"] = j.Target.connectHost()
 	mappedResults["tools_version"] = j.Target.ToolsVer
 	mappedResults["weblogic_ersion"] = j.Target.WeblogicVer
+	if len(j.Target.Labels) > 0 {
+		mappedResults["labels"] = j.Target.Labels.toMap()
+	}
+	// collector self-metrics for the target
+	mappedResults["collect_timestamp"] = start.UTC().Format(time.RFC3339Nano)
+	mappedResults["collect_duration_ms"] = time.Since(start).Milliseconds()
 	//srvlog.Debug("Final Results: " + fmt.Sprintf("%#v", mappedResults))
-	return mappedResults
+	return mappedResults, skipped
 }
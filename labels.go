@@ -0,0 +1,54 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Free-form key=value labels of a target, ie team=hcm,dc=east,tier=gold.
+// Added to every record of the target as the "labels" field.
+type Labels map[string]string
+
+// Parse the comma separated key=value inventory column
+func ParseLabels(value string) (Labels, error) {
+	labels := make(Labels)
+	value = strings.TrimSpace(value)
+	if value == "" || value == "-" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 || key == "" {
+			return nil, errors.New("Invalid label \"" + pair + "\", expecting key=value")
+		}
+		labels[key] = strings.TrimSpace(keyValue[1])
+	}
+	return labels, nil
+}
+
+// Inventory column format, keys sorted
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+l[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// copy for the metric record, so consumers can't change the target's labels
+func (l Labels) toMap() map[string]string {
+	labels := make(map[string]string, len(l))
+	for key, value := range l {
+		labels[key] = value
+	}
+	return labels
+}
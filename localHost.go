@@ -0,0 +1,215 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DNS answers are cached, virtual hostnames can move between cluster nodes
+	localDNSCacheTTL = 5 * time.Minute
+	// a slow DNS server shouldn't hold up the inventory load, no answer is cached like any other
+	dnsLookupTimeout = 2 * time.Second
+	// hosts resolved at the same time while loading the inventory
+	dnsParallelLookups = 8
+)
+
+// Names and addresses identifying the current host
+type localIdentity struct {
+	hostName  string
+	shortHost string
+	aliases   map[string]bool // configured HostAliases
+	addrs     map[string]bool // interface addresses
+	dns       *dnsCache
+	useDNS    bool // DNS aliases and virtual hostnames, only checked with LocalInventoryOnly
+}
+
+type dnsEntry struct {
+	names   []string
+	expires time.Time
+}
+
+// forward and reverse lookups cached for localDNSCacheTTL
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+func (c *dnsCache) lookup(key string, resolve func() []string) []string {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.names
+	}
+	entry = dnsEntry{names: resolve(), expires: time.Now().Add(localDNSCacheTTL)}
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+	return entry.names
+}
+
+func (c *dnsCache) forward(host string) []string {
+	return c.lookup("forward:"+host, func() []string {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		defer cancel()
+		addrs, _ := net.DefaultResolver.LookupHost(ctx, host)
+		return addrs
+	})
+}
+
+func (c *dnsCache) reverse(addr string) []string {
+	return c.lookup("reverse:"+addr, func() []string {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		defer cancel()
+		names, _ := net.DefaultResolver.LookupAddr(ctx, addr)
+		return names
+	})
+}
+
+func (c *dnsCache) canonical(host string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, host)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(cname, ".")
+}
+
+func shortName(host string) string {
+	return strings.Split(host, ".")[0]
+}
+
+// Current host names and interface addresses, refreshed on every inventory load
+func (cli *PsoftJmxClient) localIdentity() *localIdentity {
+	hostName, _ := os.Hostname()
+	local := &localIdentity{
+		hostName:  strings.ToLower(hostName),
+		shortHost: strings.ToLower(shortName(hostName)),
+		aliases:   make(map[string]bool),
+		addrs:     make(map[string]bool),
+		dns:       cli.dnsCache(),
+		useDNS:    cli.Config.LocalInventoryOnly,
+	}
+	for _, alias := range cli.Config.HostAliases {
+		local.aliases[strings.ToLower(alias)] = true
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		srvlog.Warn("Unable to read interface addresses: " + err.Error())
+	}
+	for _, ifAddr := range ifAddrs {
+		if ipNet, ok := ifAddr.(*net.IPNet); ok {
+			local.addrs[ipNet.IP.String()] = true
+		}
+	}
+	return local
+}
+
+// Whether an inventory host name refers to the current host, with the reason for the match
+func (local *localIdentity) isLocal(host string) (bool, string) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case host == local.hostName:
+		return true, "matches hostname"
+	case host == local.shortHost:
+		return true, "matches short hostname"
+	case local.aliases[host]:
+		return true, "configured host alias"
+	case local.aliases[shortName(host)]:
+		return true, "configured host alias (short name)"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if local.addrs[ip.String()] {
+			return true, "interface address " + ip.String()
+		}
+		return false, "address not on a local interface"
+	}
+	if !local.useDNS {
+		return false, "no hostname, alias or interface match"
+	}
+	// a DNS alias or virtual hostname pointing at one of our interfaces
+	for _, addr := range local.dns.forward(host) {
+		if ip := net.ParseIP(addr); ip != nil && local.addrs[ip.String()] {
+			return true, "resolves to interface address " + ip.String()
+		}
+	}
+	// names our interface addresses are registered under
+	for addr := range local.addrs {
+		if ip := net.ParseIP(addr); ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		for _, name := range local.dns.reverse(addr) {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if host == name || host == shortName(name) {
+				return true, "reverse DNS of interface address " + addr
+			}
+		}
+	}
+	return false, "no hostname, alias, interface or DNS match"
+}
+
+// Resolve the target hosts in parallel so the inventory load waits for the
+// slowest lookup instead of every lookup in turn.  The answers are cached.
+func (cli *PsoftJmxClient) prefetchDNS(local *localIdentity, currHost string, domainList []*PsoftDomain) {
+	hosts := []string{currHost}
+	for _, domain := range domainList {
+		hosts = append(hosts, domain.HostName)
+	}
+	seen := make(map[string]bool)
+	limit := make(chan struct{}, dnsParallelLookups)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		if seen[host] || net.ParseIP(host) != nil {
+			continue
+		}
+		seen[host] = true
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			if isLocal, _ := local.isLocal(host); !isLocal {
+				cli.resolveFQDN(host)
+			}
+		}(host)
+	}
+	wg.Wait()
+}
+
+// DNS cache shared by all inventory loads of the client
+func (cli *PsoftJmxClient) dnsCache() *dnsCache {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	if cli.dns == nil {
+		cli.dns = &dnsCache{entries: make(map[string]dnsEntry)}
+	}
+	return cli.dns
+}
+
+// Log why a host is considered local or remote, at Info when the answer changes
+func (cli *PsoftJmxClient) logLocalMatch(host string, local bool, reason string) {
+	decision := "remote"
+	if local {
+		decision = "local"
+	}
+	cli.statsMu.Lock()
+	if cli.localHosts == nil {
+		cli.localHosts = make(map[string]string)
+	}
+	changed := cli.localHosts[host] != decision+": "+reason
+	cli.localHosts[host] = decision + ": " + reason
+	cli.statsMu.Unlock()
+	if changed {
+		srvlog.Info("Target host "+host+" is "+decision, "reason", reason)
+	} else {
+		srvlog.Debug("Target host "+host+" is "+decision, "reason", reason)
+	}
+}
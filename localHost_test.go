@@ -0,0 +1,65 @@
+package psoftjmx
+
+import (
+	"testing"
+	"time"
+)
+
+// DNS cache answering from the given forward and reverse records
+func testDNSCache(records map[string][]string) *dnsCache {
+	cache := &dnsCache{entries: make(map[string]dnsEntry)}
+	for key, names := range records {
+		cache.entries[key] = dnsEntry{names: names, expires: time.Now().Add(time.Hour)}
+	}
+	return cache
+}
+
+func TestResolveFQDN(t *testing.T) {
+	cli := &PsoftJmxClient{Config: &JMXConfig{}}
+	cli.dns = testDNSCache(map[string][]string{
+		"forward:pshrweb01":             {"192.0.2.11"},
+		"reverse:192.0.2.11":            {"pshrweb01.example.edu."},
+		"forward:pshrweb02.example.edu": {"192.0.2.12"},
+		"reverse:192.0.2.12":            {"other.example.edu.", "PSHRWEB02.example.edu."},
+	})
+	tests := map[string]string{
+		"pshrweb01":             "pshrweb01.example.edu", // short name, ie from /etc/hosts
+		"pshrweb02.example.edu": "PSHRWEB02.example.edu",
+		"192.0.2.13":            "192.0.2.13",
+	}
+	for host, want := range tests {
+		if got := cli.resolveFQDN(host); got != want {
+			t.Errorf("resolveFQDN(%s) = %s, want %s", host, got, want)
+		}
+	}
+
+	// answers expire with the local identity cache
+	entry := cli.dns.entries["fqdn:pshrweb01"]
+	if ttl := time.Until(entry.expires); ttl <= 0 || ttl > localDNSCacheTTL {
+		t.Errorf("fqdn cached for %s, want at most %s", ttl, localDNSCacheTTL)
+	}
+	cli.dns.entries["reverse:192.0.2.11"] = dnsEntry{names: []string{"pshrweb01.example.org."}, expires: time.Now().Add(time.Hour)}
+	entry.expires = time.Now().Add(-time.Second)
+	cli.dns.entries["fqdn:pshrweb01"] = entry
+	if got := cli.resolveFQDN("pshrweb01"); got != "pshrweb01.example.org" {
+		t.Errorf("after expiry resolveFQDN = %s, want the new pshrweb01.example.org", got)
+	}
+}
+
+func TestIsLocalDNSOnlyWithLocalInventory(t *testing.T) {
+	for _, localOnly := range []bool{false, true} {
+		cli := &PsoftJmxClient{Config: &JMXConfig{LocalInventoryOnly: localOnly, HostAliases: []string{"hrvip"}}}
+		cli.dns = testDNSCache(map[string][]string{"forward:pshrweb01.example.edu": {"127.0.0.1"}})
+		local := cli.localIdentity()
+		if isLocal, reason := local.isLocal("hrvip.example.edu"); !isLocal {
+			t.Errorf("LocalInventoryOnly %t: alias not local: %s", localOnly, reason)
+		}
+		if isLocal, _ := local.isLocal("127.0.0.1"); !isLocal {
+			t.Errorf("LocalInventoryOnly %t: loopback address not local", localOnly)
+		}
+		// resolves to an interface address, only looked up with LocalInventoryOnly
+		if isLocal, reason := local.isLocal("pshrweb01.example.edu"); isLocal != localOnly {
+			t.Errorf("LocalInventoryOnly %t: DNS match = %t (%s)", localOnly, isLocal, reason)
+		}
+	}
+}
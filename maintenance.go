@@ -0,0 +1,298 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// EndTime formats, a date alone ends with that day
+	endTimeFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+	// serializes the read-modify-write of the blackout and exclusion files
+	// within the process, lockMaintenanceFile across processes
+	maintenanceFileMu sync.Mutex
+)
+
+// End of a blackout or exclusion, false when blank or not a known format
+func ParseEndTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, format := range endTimeFormats {
+		endTime, err := time.ParseInLocation(format, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if format == "2006-01-02" {
+			endTime = endTime.AddDate(0, 0, 1)
+		}
+		return endTime, true
+	}
+	return time.Time{}, false
+}
+
+// EndTime written by the management methods
+func formatEndTime(until time.Time) string {
+	if until.IsZero() {
+		return ""
+	}
+	return until.Format(time.RFC3339)
+}
+
+// Whether the blackout has ended, a blackout without a readable EndTime never does
+func (blackout *BlackoutType) Expired(now time.Time) bool {
+	endTime, ok := ParseEndTime(blackout.EndTime)
+	return ok && !now.Before(endTime)
+}
+
+// Whether the exclusion has ended, an exclusion without a readable EndTime never does
+func (exclusion *ExcludeDomainType) Expired(now time.Time) bool {
+	endTime, ok := ParseEndTime(exclusion.EndTime)
+	return ok && !now.Before(endTime)
+}
+
+// Add a blackout to the file, replacing any other blackout of the same
+// DomainEnv.  The file is replaced in one step, comments and other lines kept.
+func SetBlackout(path string, blackout BlackoutType) error {
+	if err := checkMaintenanceFields(blackout.DomainEnv, blackout.EndTime, blackout.Descr, blackout.Author); err != nil {
+		return err
+	}
+	if isBlackoutSelector(blackout.DomainEnv) {
+		if _, err := parseBlackoutSelector(blackout.DomainEnv); err != nil {
+			return fmt.Errorf("Invalid blackout %q: %s", blackout.DomainEnv, err)
+		}
+	}
+	record := []string{blackout.DomainEnv, blackout.EndTime, blackout.Descr}
+	if blackout.Author != "" {
+		record = append(record, blackout.Author)
+	}
+	_, err := editMaintenanceFile(path, '|', blackout.DomainEnv, record)
+	return err
+}
+
+// Remove every blackout of a DomainEnv from the file, returns how many were removed
+func DeleteBlackout(path string, domainEnv string) (int, error) {
+	if err := checkMaintenanceFields(domainEnv, ""); err != nil {
+		return 0, err
+	}
+	return editMaintenanceFile(path, '|', domainEnv, nil)
+}
+
+// Add an exclusion to the file, replacing any other exclusion of the domain
+func SetExclusion(path string, exclusion ExcludeDomainType) error {
+	if err := checkMaintenanceFields(exclusion.DomainName, exclusion.EndTime, exclusion.Reason, exclusion.Author); err != nil {
+		return err
+	}
+	record := []string{exclusion.DomainName}
+	if exclusion.EndTime != "" || exclusion.Author != "" || exclusion.Reason != "" {
+		record = append(record, exclusion.EndTime, exclusion.Author, exclusion.Reason)
+	}
+	_, err := editMaintenanceFile(path, ',', exclusion.DomainName, record)
+	return err
+}
+
+// Remove every exclusion of a domain from the file, returns how many were removed
+func DeleteExclusion(path string, domainName string) (int, error) {
+	if err := checkMaintenanceFields(domainName, ""); err != nil {
+		return 0, err
+	}
+	return editMaintenanceFile(path, ',', domainName, nil)
+}
+
+func checkMaintenanceFields(key string, endTime string, fields ...string) error {
+	if strings.TrimSpace(key) == "" {
+		return errors.New("A domain, env or selector is required")
+	}
+	if strings.HasPrefix(key, "#") {
+		return errors.New("A domain, env or selector can't start with #")
+	}
+	if _, ok := ParseEndTime(endTime); endTime != "" && !ok {
+		return fmt.Errorf("Invalid end time %q, expecting RFC3339 or YYYY-MM-DD HH:MM", endTime)
+	}
+	for _, field := range append(fields, key, endTime) {
+		if strings.ContainsAny(field, "\r\n") {
+			return errors.New("Values can't span lines")
+		}
+	}
+	return nil
+}
+
+// Drop the lines whose first field is key and append record, if any.  A
+// missing file is created.  Returns the number of lines dropped.
+// Exclusive flock on a .lock file beside path, the file itself is replaced
+// by a rename so it can't hold the lock; two psoftjmxctl runs or a program
+// using SetBlackout may edit the same file
+func lockMaintenanceFile(path string) (func(), error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("Unable to lock %s: %s", path, err)
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+func editMaintenanceFile(path string, comma rune, key string, record []string) (int, error) {
+	if path == "" {
+		return 0, errors.New("No file configured")
+	}
+	maintenanceFileMu.Lock()
+	defer maintenanceFileMu.Unlock()
+	unlock, err := lockMaintenanceFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	perm := os.FileMode(0644)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	var out bytes.Buffer
+	removed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			r := csv.NewReader(strings.NewReader(line))
+			r.Comma = comma
+			r.FieldsPerRecord = -1
+			if fields, err := r.Read(); err == nil && len(fields) > 0 && fields[0] == key {
+				removed++
+				continue
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if record != nil {
+		w := csv.NewWriter(&out)
+		w.Comma = comma
+		w.Write(record)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return 0, err
+		}
+	}
+	if err := writeFileAtomic(path, out.Bytes(), perm); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// Replace a file in one step, readers see the old or the new content
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if err = tmpFile.Chmod(perm); err == nil {
+		_, err = tmpFile.Write(data)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Black out a domain, an ENV<app><env> or a selector until the given time
+// (zero for no end) and apply it from the next cycle.
+func (cli *PsoftJmxClient) AddBlackout(domainEnv string, until time.Time, author string, reason string) error {
+	err := SetBlackout(cli.Config.PathBlackoutFile, BlackoutType{
+		DomainEnv: domainEnv,
+		EndTime:   formatEndTime(until),
+		Descr:     reason,
+		Author:    author,
+	})
+	if err != nil {
+		return err
+	}
+	srvlog.Info("Blackout added", "target", domainEnv, "until", formatEndTime(until), "author", author, "reason", reason)
+	return cli.LoadBlackouts()
+}
+
+// Remove the blackouts of a domain, ENV<app><env> or selector, returns how many were removed
+func (cli *PsoftJmxClient) RemoveBlackout(domainEnv string) (int, error) {
+	removed, err := DeleteBlackout(cli.Config.PathBlackoutFile, domainEnv)
+	if err != nil {
+		return 0, err
+	}
+	srvlog.Info("Blackout removed", "target", domainEnv, "removed", removed)
+	return removed, cli.LoadBlackouts()
+}
+
+// Blackouts in the file, including expired ones
+func (cli *PsoftJmxClient) ListBlackouts() ([]*BlackoutType, error) {
+	blackoutList, err := ReadBlackouts(cli.Config.PathBlackoutFile)
+	if os.IsNotExist(err) {
+		return []*BlackoutType{}, nil
+	}
+	return blackoutList, err
+}
+
+// Stop monitoring a domain until the given time (zero for no end) and apply it from the next cycle
+func (cli *PsoftJmxClient) AddExclusion(domainName string, until time.Time, author string, reason string) error {
+	err := SetExclusion(cli.Config.PathExclusionFile, ExcludeDomainType{
+		DomainName: domainName,
+		EndTime:    formatEndTime(until),
+		Author:     author,
+		Reason:     reason,
+	})
+	if err != nil {
+		return err
+	}
+	srvlog.Info("Exclusion added", "domain", domainName, "until", formatEndTime(until), "author", author, "reason", reason)
+	return cli.LoadExclusions()
+}
+
+// Remove the exclusions of a domain, returns how many were removed
+func (cli *PsoftJmxClient) RemoveExclusion(domainName string) (int, error) {
+	removed, err := DeleteExclusion(cli.Config.PathExclusionFile, domainName)
+	if err != nil {
+		return 0, err
+	}
+	srvlog.Info("Exclusion removed", "domain", domainName, "removed", removed)
+	return removed, cli.LoadExclusions()
+}
+
+// Exclusions in the file, including expired ones
+func (cli *PsoftJmxClient) ListExclusions() ([]*ExcludeDomainType, error) {
+	exclusionList, err := ReadExclusions(cli.Config.PathExclusionFile)
+	if os.IsNotExist(err) {
+		return []*ExcludeDomainType{}, nil
+	}
+	return exclusionList, err
+}
@@ -0,0 +1,138 @@
+package psoftjmx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadMaintenanceFilesExtraFields(t *testing.T) {
+	dir := t.TempDir()
+	blackoutPath := writeTestFile(t, dir, "blackout.txt",
+		"# DomainEnv|EndTime|Descr|Author\n"+
+			"HRWEB1\n"+
+			"HRWEB2||patching|jdoe|extra\n")
+	if _, err := ReadBlackouts(blackoutPath); err == nil || !strings.Contains(err.Error(), blackoutPath+":3:") {
+		t.Errorf("got %v, want an error on %s:3", err, blackoutPath)
+	}
+	exclusionPath := writeTestFile(t, dir, "exclude.txt",
+		"HRWEB1,2030-01-01,jdoe,peak load\n"+
+			"HRWEB2,,,,\n")
+	if _, err := ReadExclusions(exclusionPath); err == nil || !strings.Contains(err.Error(), exclusionPath+":2:") {
+		t.Errorf("got %v, want an error on %s:2", err, exclusionPath)
+	}
+
+	// fewer fields than the struct are fine
+	writeTestFile(t, dir, "blackout.txt", "HRWEB1\nHRWEB2|2030-01-01|patching\n")
+	blackouts, err := ReadBlackouts(blackoutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blackouts) != 2 || blackouts[0].DomainEnv != "HRWEB1" || blackouts[1].Descr != "patching" || blackouts[1].Author != "" {
+		t.Errorf("got %+v %+v", blackouts[0], blackouts[1])
+	}
+	writeTestFile(t, dir, "exclude.txt", "HRWEB1\nHRWEB2,,jdoe,peak load\n")
+	exclusions, err := ReadExclusions(exclusionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exclusions) != 2 || exclusions[0].EndTime != "" || exclusions[1].Author != "jdoe" || exclusions[1].Reason != "peak load" {
+		t.Errorf("got %+v %+v", exclusions[0], exclusions[1])
+	}
+}
+
+func TestSetAndDeleteBlackout(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "blackout.txt", "# kept\nHRWEB1||old\n")
+	if err := SetBlackout(path, BlackoutType{DomainEnv: "HRWEB1", EndTime: "2030-01-01 06:00", Descr: "patching, phase 2", Author: "jdoe"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBlackout(path, BlackoutType{DomainEnv: "app=HR;type=web", Descr: "release"}); err != nil {
+		t.Fatal(err)
+	}
+	blackouts, err := ReadBlackouts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blackouts) != 2 {
+		t.Fatalf("got %d blackouts, want 2", len(blackouts))
+	}
+	if got := *blackouts[0]; got.DomainEnv != "HRWEB1" || got.Descr != "patching, phase 2" || got.Author != "jdoe" {
+		t.Errorf("replaced blackout = %+v", got)
+	}
+	if blackouts[1].selector == nil {
+		t.Errorf("selector blackout wasn't parsed")
+	}
+
+	if removed, err := DeleteBlackout(path, "HRWEB1"); err != nil || removed != 1 {
+		t.Errorf("DeleteBlackout = %d, %v, want 1 removed", removed, err)
+	}
+	if blackouts, _ := ReadBlackouts(path); len(blackouts) != 1 || blackouts[0].DomainEnv != "app=HR;type=web" {
+		t.Errorf("after delete: %v", blackouts)
+	}
+	if err := SetBlackout(path, BlackoutType{DomainEnv: "HRWEB1", EndTime: "tomorrow"}); err == nil {
+		t.Error("want an error for an invalid EndTime")
+	}
+	for _, key := range []string{"", " ", "# kept"} {
+		if _, err := DeleteBlackout(path, key); err == nil {
+			t.Errorf("DeleteBlackout %q: want an error", key)
+		}
+		if _, err := DeleteExclusion(path, key); err == nil {
+			t.Errorf("DeleteExclusion %q: want an error", key)
+		}
+	}
+}
+
+func TestMaintenanceExpiry(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		endTime string
+		expired bool
+	}{
+		{"", false},
+		{"not a time", false},
+		{"2030-01-01 11:59", true},
+		{"2030-01-01 12:00", true},
+		{"2030-01-01T12:01", false},
+		{"2030-01-01", false}, // a date ends with that day
+		{"2029-12-31", true},
+		{now.Add(-time.Minute).Format(time.RFC3339), true},
+	}
+	for _, test := range tests {
+		blackout := &BlackoutType{DomainEnv: "HRWEB1", EndTime: test.endTime}
+		if got := blackout.Expired(now); got != test.expired {
+			t.Errorf("blackout EndTime %q: expired = %t, want %t", test.endTime, got, test.expired)
+		}
+		if got := blackout.active(now); got == test.expired {
+			t.Errorf("blackout EndTime %q: active = %t", test.endTime, got)
+		}
+		exclusion := &ExcludeDomainType{DomainName: "HRWEB1", EndTime: test.endTime}
+		if got := exclusion.Expired(now); got != test.expired {
+			t.Errorf("exclusion EndTime %q: expired = %t, want %t", test.endTime, got, test.expired)
+		}
+	}
+}
+
+func TestEditMaintenanceFileLock(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "blackout.txt", "HRWEB1\n")
+	// another process holding the lock
+	unlock, err := lockMaintenanceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- SetBlackout(path, BlackoutType{DomainEnv: "HRWEB2"})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("SetBlackout returned %v while the file was locked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if blackouts, _ := ReadBlackouts(path); len(blackouts) != 2 {
+		t.Errorf("got %d blackouts, want 2", len(blackouts))
+	}
+}
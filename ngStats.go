@@ -0,0 +1,96 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ng-stats lines are <command class>: <run count>/<active sessions>
+	ngStatsLine    = regexp.MustCompile(`^(\S+):\s*(\d+)/(\d+)$`)
+	ngStatsVersion = regexp.MustCompile(`^NailGun server version (\S+)`)
+)
+
+// Usage of a single command by the Nailgun server
+type NailGunCommandStats struct {
+	Command string // full command class name
+	Runs    int    // times the command has been run
+	Active  int    // sessions currently running the command
+}
+
+// Statistics reported by the Nailgun server's ng-stats command
+type NailGunStats struct {
+	Version        string
+	Commands       []NailGunCommandStats
+	TotalRuns      int
+	ActiveSessions int
+}
+
+// Convert the raw ng-stats report to NailGunStats
+func ParseNailGunStats(rawStats string) (*NailGunStats, error) {
+	stats := &NailGunStats{}
+	for _, line := range strings.Split(rawStats, "\n") {
+		line = strings.TrimSpace(line)
+		if match := ngStatsVersion.FindStringSubmatch(line); match != nil {
+			stats.Version = match[1]
+			continue
+		}
+		match := ngStatsLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		runs, _ := strconv.Atoi(match[2])
+		active, _ := strconv.Atoi(match[3])
+		stats.Commands = append(stats.Commands, NailGunCommandStats{Command: match[1], Runs: runs, Active: active})
+		stats.TotalRuns += runs
+		stats.ActiveSessions += active
+	}
+	if stats.Version == "" && len(stats.Commands) == 0 && strings.TrimSpace(rawStats) != "" {
+		return nil, errors.New("Unrecognized ng-stats output: " + rawStats)
+	}
+	return stats, nil
+}
+
+// Current Nailgun server statistics for the client's Nailgun connection
+func (cli *PsoftJmxClient) NailGunStats() (*NailGunStats, error) {
+	conn := &JMXConnection{NGAddress: cli.Config.NailgunServerConn}
+	return conn.GetNailGunStats()
+}
+
+// Synthetic "collector" record reporting the health of the collector itself,
+// emitted with the domain metrics so it can be dashboarded next to them
+func (cli *PsoftJmxClient) collectorMetrics() map[string]interface{} {
+	hostName, _ := os.Hostname()
+	collector := make(map[string]interface{})
+	collector["domain_name"] = "psoftjmx"
+	collector["domain_type"] = "collector"
+	collector["host"] = hostName
+	collector["version"] = psoftjmxAPIVersion
+	domainList, _, _ := cli.snapshot()
+	collector["collector.targets"] = len(domainList)
+	cli.LastCycle().addTo(collector)
+
+	stats, err := cli.NailGunStats()
+	if err != nil {
+		srvlog.Error("Unable to get Nailgun Stats: " + err.Error())
+		collector["errorMsg"] = err.Error()
+		collector["status"] = statusForError(err)
+		return collector
+	}
+	collector["status"] = "Up"
+	collector["nailgun.version"] = stats.Version
+	collector["nailgun.total_runs"] = stats.TotalRuns
+	collector["nailgun.active_sessions"] = stats.ActiveSessions
+	for _, cmd := range stats.Commands {
+		// use the short class name, ie JMXQuery
+		name := cmd.Command[strings.LastIndex(cmd.Command, ".")+1:]
+		collector["nailgun.command."+name+".runs"] = cmd.Runs
+		collector["nailgun.command."+name+".active"] = cmd.Active
+	}
+	return collector
+}
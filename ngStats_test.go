@@ -0,0 +1,72 @@
+package psoftjmx_test
+
+import (
+	"testing"
+
+	"github.com/UMN-PeopleSoft/psoftjmx"
+	"github.com/UMN-PeopleSoft/psoftjmx/psoftjmxtest"
+)
+
+func TestParseNailGunStats(t *testing.T) {
+	stats, err := psoftjmx.ParseNailGunStats("NailGun server version 0.9.1\n\n" +
+		"edu.umn.pssa.jmxquery.JMXQuery: 12/2\n" +
+		"com.facebook.nailgun.builtins.NGStats: 3/1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Version != "0.9.1" || len(stats.Commands) != 2 || stats.TotalRuns != 15 || stats.ActiveSessions != 3 {
+		t.Errorf("got %+v", stats)
+	}
+	if command := stats.Commands[0]; command.Command != psoftjmxtest.JMXQueryCommand || command.Runs != 12 || command.Active != 2 {
+		t.Errorf("JMXQuery stats = %+v", command)
+	}
+	if _, err := psoftjmx.ParseNailGunStats("Exception in thread main\n"); err == nil {
+		t.Error("want an error for output that isn't ng-stats")
+	}
+	if stats, err := psoftjmx.ParseNailGunStats(""); err != nil || len(stats.Commands) != 0 {
+		t.Errorf("empty output: got %+v, %v", stats, err)
+	}
+}
+
+func TestCollectorRecord(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n")
+	server.HandleDefault(psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12")))
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	metrics, err := client.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collector map[string]interface{}
+	for _, record := range metrics {
+		if record["domain_type"] == "collector" {
+			collector = record
+		}
+	}
+	if collector == nil {
+		t.Fatalf("no collector record in %v", metrics)
+	}
+	want := map[string]interface{}{
+		"domain_name":                     "psoftjmx",
+		"status":                          "Up",
+		"nailgun.version":                 "1.0.0",
+		"nailgun.command.JMXQuery.runs":   2,
+		"nailgun.command.JMXQuery.active": 0,
+		"collector.targets":               2,
+		"cycle.targets":                   2,
+		"cycle.ok":                        2,
+	}
+	for key, value := range want {
+		if collector[key] != value {
+			t.Errorf("collector %s = %v (%T), want %v", key, collector[key], collector[key], value)
+		}
+	}
+	if runs, _ := collector["nailgun.total_runs"].(int); runs < 2 {
+		t.Errorf("nailgun.total_runs = %v, want at least the 2 queries", collector["nailgun.total_runs"])
+	}
+}
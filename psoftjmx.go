@@ -4,57 +4,105 @@ package psoftjmx
 
 import (
 	log "github.com/inconshreveable/log15"
-	"strings"
 	"os"
+	"strings"
+	"time"
 )
 
 // core configuration settings to pull metrics
 type JMXConfig struct {
-	PathInventoryFile   string
-	PathBlackoutFile    string
-	PathExclusionFile   string
-	AttribWebMetrics    string
-	AttribAppMetrics    string
-	AttribPrcMetrics    string
-	LogLevel            string
-	ConcurrentWorkers   int
-	NailgunServerConn   string
-	JavaPath            string
-	DomainInventoryFile string
+	PathInventoryFile         string
+	PathBlackoutFile          string
+	PathExclusionFile         string
+	PathCredentialsFile       string         // yaml rules filling in blank JMXUser/JMXPassword by app, env, type or domain
+	PathBlackoutCalendar      string         // iCalendar (.ics) export of the change calendar, events become blackouts through CalendarRules
+	CalendarRules             []CalendarRule // calendar event category or summary to blackout target
+	AttribWebMetrics          string
+	AttribAppMetrics          string
+	AttribPrcMetrics          string
+	LogLevel                  string
+	ConcurrentWorkers         int
+	NailgunServerConn         string
+	JavaPath                  string
+	DomainInventoryFile       string            // deprecated, read as one more InventoryFiles entry
+	InventoryFiles            []string          // more inventory files, directories or globs (ie inventory.d/*.txt) merged after PathInventoryFile
+	RejectDuplicateDomains    bool              // a target defined in more than one inventory file is an error instead of the later file winning
+	AnsibleDomainsVar         string            // Ansible host variable listing the host's PeopleSoft domains, default psoft_domains
+	AnsibleVars               map[string]string // inventory column to Ansible variable, ie "JMXPort": "weblogic_jmx_port"
+	DiscoverCfgHomes          []string          // PS_CFG_HOME directories scanned for app, prc and web domains on this host
+	DiscoverJMXPortKey        string            // psappsrv.cfg/psprcs.cfg key of the JMX agent port, "Section/Key" or a key in any section, default "RMI Port"
+	DiscoverJMXUser           string            // JMX user of discovered domains
+	DiscoverJMXPassword       string            // JMX password of discovered domains
+	AdminExpansionInterval    time.Duration     // how often AdminServer targets are asked for their running managed servers, default 10m
+	SQLInventoryDriver        string            // database/sql driver, registered by the application, ie sqlite3
+	SQLInventoryDSN           string            // data source name of the inventory database
+	SQLInventoryQuery         string            // query returning one target per row, columns named after the inventory columns
+	SQLInventoryColumns       map[string]string // result column to inventory column, when the query can't alias them
+	SQLInventoryCacheFile     string            // last good SQL inventory, used when the database is down at startup
+	SQLInventoryInterval      time.Duration     // how often the SQL inventory is queried, default 5m
 	ConcatenateDomainWithHost bool
-	UseLastXCharactersOfHost int
-	LocalInventoryOnly  bool
-	
+	UseLastXCharactersOfHost  int
+	LocalInventoryOnly        bool           // only monitor targets on this host, also matched through DNS aliases and reverse DNS
+	UseExternalNailgun        bool           // connect to a running Nailgun server at NailgunServerConn instead of starting one
+	JMXCredentialFile         bool           // pass JMX credentials in a one-time -credfile instead of -u/-p, needs a local Nailgun server and a JMXQuery build with -credfile
+	PollIntervals             []PollInterval // Scheduler intervals by domain type, purpose or domain, first match wins
+	DefaultPollInterval       time.Duration  // Scheduler interval for targets not in PollIntervals
+	MaxRequestsPerHost        int            // max concurrent JMX requests to a single host, 0 for no limit
+	Retry                     RetryPolicy    // retry of transient JMX failures, no retry by default
+	BreakerThreshold          int            // consecutive Down results before a domain is only probed, 0 disables
+	BreakerProbeInterval      time.Duration  // how often a suppressed domain is probed, default 10m
+	LocalConnectAddress       string         // address used to connect to targets on this host, default localhost
+	HostAliases               []string       // other names of this host, ie cluster virtual hostnames
+	DomainNameTemplate        string         // go template for the reported domain name, ie {{.DomainName}}{{suffix 2 .ShortHost}}
+	JMXDomainTemplate         string         // go template for the Tuxedo domain name in the JMX URL, default the inventory name
+	WatchFiles                bool           // reload the inventory, blackout and exclusion files only when they change
+
 }
 
 var (
-	defaultConcatwithHost  = false
-   defaultLastNumChars    = 0
-   defaultLocalInventory  = false
-	defaultParallelWorkers = 5
-	defaulLogLevel         = "INFO"
-	logFile                = "logs/psoftjmx.log"
-	srvlog                 = log.New("module", "psoftjmx")
-	defaultNGSocket        = ""
+	defaultConcatwithHost     = false
+	defaultLastNumChars       = 0
+	defaultLocalInventory     = false
+	defaultLocalConnect       = "localhost"
+	defaultAnsibleDomainsVar  = "psoft_domains"
+	defaultDiscoverJMXPortKey = "RMI Port"
+	defaultAdminExpansion     = 10 * time.Minute
+	defaultSQLInventory       = 5 * time.Minute
+	defaultParallelWorkers    = 5
+	defaulLogLevel            = "INFO"
+	logFile                   = "logs/psoftjmx.log"
+	srvlog                    = log.New("module", "psoftjmx")
+	defaultNGSocket           = ""
+	runDir                    = "run" // nailgun socket and one-time credential files
 )
 
 const (
 	psoftjmxAPIVersion = "1.1"
 )
 
-func init() {
-	wd, _ := os.Getwd()
-	_ = os.MkdirAll(wd + "/logs", 0700)
-	_ = os.MkdirAll(wd + "/run", 0700)
-	defaultNGSocket = "local:" + wd + "run/psmetric.socket"
-	
-	srvlog.SetHandler(log.LvlFilterHandler(
-		log.LvlInfo,
-		log.Must.FileHandler(logFile, log.LogfmtFormat())))
+// Create the logs and run directories in the working directory, done by
+// NewClient so importing the package (ie psoftjmxctl) leaves the cwd alone
+func setupWorkDir() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(wd+"/logs", 0700); err != nil {
+		return err
+	}
+	runDir = wd + "/run"
+	if err := os.MkdirAll(runDir, 0700); err != nil {
+		return err
+	}
+	defaultNGSocket = "local:" + runDir + "/psmetric.socket"
+	return nil
 }
 
 func NewClient(config *JMXConfig) (*PsoftJmxClient, error) {
 	jmxClient := &PsoftJmxClient{}
+	if err := setupWorkDir(); err != nil {
+		return nil, err
+	}
 	if config.ConcurrentWorkers == 0 {
 		config.ConcurrentWorkers = defaultParallelWorkers
 	}
@@ -64,10 +112,25 @@ func NewClient(config *JMXConfig) (*PsoftJmxClient, error) {
 	if config.NailgunServerConn == "" {
 		config.NailgunServerConn = defaultNGSocket
 	}
+	if config.LocalConnectAddress == "" {
+		config.LocalConnectAddress = defaultLocalConnect
+	}
+	if config.AnsibleDomainsVar == "" {
+		config.AnsibleDomainsVar = defaultAnsibleDomainsVar
+	}
+	if config.DiscoverJMXPortKey == "" {
+		config.DiscoverJMXPortKey = defaultDiscoverJMXPortKey
+	}
+	if config.AdminExpansionInterval == 0 {
+		config.AdminExpansionInterval = defaultAdminExpansion
+	}
+	if config.SQLInventoryInterval == 0 {
+		config.SQLInventoryInterval = defaultSQLInventory
+	}
 	if config.UseLastXCharactersOfHost == 0 {
 		config.UseLastXCharactersOfHost = defaultLastNumChars
 	}
-		
+
 	// conver standard java Log level to go log level
 	logStr := strings.ToLower(config.LogLevel)
 	if logStr == "all" {
@@ -77,31 +140,44 @@ func NewClient(config *JMXConfig) (*PsoftJmxClient, error) {
 		logStr = "warn"
 	}
 	logcode, _ := log.LvlFromString(logStr)
-	srvlog.SetHandler(log.LvlFilterHandler(
-		logcode,
-		log.Must.FileHandler(logFile, log.LogfmtFormat())))
+	fileHandler, err := log.FileHandler(logFile, log.LogfmtFormat())
+	if err != nil {
+		return nil, err
+	}
+	srvlog.SetHandler(log.LvlFilterHandler(logcode, fileHandler))
 
 	srvlog.Debug("Loading Configuration for client")
 	jmxClient.Config = config
+	if !config.JMXCredentialFile {
+		srvlog.Warn("JMX passwords are passed to JMXQuery as -p on the Nailgun command line, set JMXCredentialFile to use one-time credential files")
+	}
 	// preload-verify JMX attribute configs
 	jmxClient.Attributes = new(JMXAttributes)
-	err := jmxClient.CacheJMXAttributes()
+	err = jmxClient.CacheJMXAttributes()
 	if err != nil {
 		return nil, err
 	}
 	srvlog.Debug("Cached Attributes/Metrics")
 	// startup and verify the NailGun server is running
-	err = jmxClient.InitNailGunServer()
-	if err != nil {
-		return nil, err
+	if !config.UseExternalNailgun {
+		err = jmxClient.InitNailGunServer()
+		if err != nil {
+			return nil, err
+		}
+		srvlog.Debug("Started NailGun Server")
 	}
-	srvlog.Debug("Started NailGun Server")
 	// verify valid domain inventory file, will reload before calling fetch
 	err = jmxClient.LoadTargets()
 	if err != nil {
 		return nil, err
 	}
 	srvlog.Debug("Test Load of Targets seccessful, ready to capture metrics")
+	if config.WatchFiles {
+		err = jmxClient.WatchFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
 	// client is ready to handle fetch requests
 	return jmxClient, nil
 
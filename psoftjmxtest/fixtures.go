@@ -0,0 +1,40 @@
+// Poeplesoft Metric Capture via JMX - test support
+
+package psoftjmxtest
+
+import (
+	"github.com/UMN-PeopleSoft/psoftjmx"
+	"gopkg.in/yaml.v2"
+)
+
+// Build a single JMXQuery result line
+func Result(mBeanName, attribute, value string) psoftjmx.JMXQueryResults {
+	return psoftjmx.JMXQueryResults{
+		MBeanName:     mBeanName,
+		Attribute:     attribute,
+		AttributeType: "java.lang.String",
+		Value:         value,
+	}
+}
+
+// Format results the same way the JMXQuery client prints them
+func Results(results ...psoftjmx.JMXQueryResults) string {
+	out, err := yaml.Marshal(results)
+	if err != nil {
+		panic(err)
+	}
+	return string(out)
+}
+
+// Fixture returning the formatted results with a zero exit code
+func Respond(results ...psoftjmx.JMXQueryResults) Fixture {
+	return Fixture{Output: Results(results...)}
+}
+
+// Fixture for a target rejecting the configured JMX user/password
+func AuthFailure() Fixture {
+	return Fixture{
+		Stderr:   "java.lang.SecurityException: Invalid user name or password\n",
+		ExitCode: ExitAuthFailure,
+	}
+}
@@ -0,0 +1,411 @@
+// Poeplesoft Metric Capture via JMX - test support
+
+// Package psoftjmxtest provides an in-process Nailgun server that answers the
+// JMXQuery and ng-stats commands from scripted fixtures, so the psoftjmx client,
+// metric mapping and worker pool can be run without Java, Weblogic or Tuxedo.
+package psoftjmxtest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// command names the psoftjmx client sends to the Nailgun server
+	JMXQueryCommand = "edu.umn.pssa.jmxquery.JMXQuery"
+	StatsCommand    = "ng-stats"
+	StopCommand     = "ng-stop"
+
+	// Nailgun exit codes
+	ExitAuthFailure   = 899 // JMXQuery: invalid user/password
+	ExitNoSuchCommand = 898 // Nailgun: command/class not found
+
+	nailgunVersion = "1.0.0"
+)
+
+// Nailgun protocol chunk types
+const (
+	chunkArgument  = 'A'
+	chunkEnv       = 'E'
+	chunkDir       = 'D'
+	chunkCommand   = 'C'
+	chunkStdin     = '0'
+	chunkStdinEOF  = '.'
+	chunkHeartbeat = 'H'
+	chunkStdout    = '1'
+	chunkStderr    = '2'
+	chunkExit      = 'X'
+)
+
+// Scripted response for a JMXQuery call
+type Fixture struct {
+	Output   string        // stdout sent back, normally the JMXQuery yaml results
+	Stderr   string        // stderr sent back, ie a java stack trace
+	ExitCode int           // JMXQuery exit code, ie 899 for a bad user/password
+	Delay    time.Duration // wait before responding to simulate slow targets
+}
+
+// A command received by the server
+type Command struct {
+	Name     string
+	Args     []string
+	Env      map[string]string
+	Dir      string
+	User     string // JMX user from -u or the -credfile
+	Password string // JMX password from -p or the -credfile
+}
+
+// Value of a "-flag value" pair in the command arguments
+func (c Command) Arg(flag string) string {
+	for i := 0; i < len(c.Args)-1; i++ {
+		if c.Args[i] == flag {
+			return c.Args[i+1]
+		}
+	}
+	return ""
+}
+
+type route struct {
+	match   string
+	fixture Fixture
+	once    bool // removed after its first use
+}
+
+type commandStats struct {
+	runs      int
+	active    int
+	maxActive int
+}
+
+// In-process Nailgun server answering from fixtures
+type Server struct {
+	Address   string // address to use for psoftjmx NailgunServerConn
+	listener  net.Listener
+	socketDir string
+
+	mu             sync.Mutex
+	routes         []route
+	defaultFixture *Fixture
+	requireUser    string
+	requirePass    string
+	requireCreds   bool
+	commands       []Command
+	stats          map[string]*commandStats
+
+	wg sync.WaitGroup
+}
+
+// Start a server listening on a unix socket in a temporary directory
+func NewServer() (*Server, error) {
+	dir, err := ioutil.TempDir("", "psoftjmxtest")
+	if err != nil {
+		return nil, err
+	}
+	socketFile := filepath.Join(dir, "ng.socket")
+	l, err := net.Listen("unix", socketFile)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s := newServer(l, "local:"+socketFile)
+	s.socketDir = dir
+	return s, nil
+}
+
+// Start a server listening on a random tcp port of the loopback interface
+func NewTCPServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newServer(l, l.Addr().String()), nil
+}
+
+func newServer(l net.Listener, address string) *Server {
+	s := &Server{
+		Address:  address,
+		listener: l,
+		stats:    make(map[string]*commandStats),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Respond with the fixture to any JMXQuery whose -url contains match,
+// ie the domain name or host:port.  The first matching fixture is used.
+func (s *Server) Handle(match string, f Fixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, route{match: match, fixture: f})
+}
+
+// Like Handle, but only for the next matching JMXQuery, ie a failure before
+// the Handle fixture answers the retry
+func (s *Server) HandleOnce(match string, f Fixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, route{match: match, fixture: f, once: true})
+}
+
+// Respond with the fixture to any JMXQuery that has no matching Handle
+func (s *Server) HandleDefault(f Fixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultFixture = &f
+}
+
+// Answer any JMXQuery whose -u/-p or -credfile doesn't hold this user/password with
+// an authentication failure, the way JMXQuery does
+func (s *Server) RequireCredentials(user string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireUser = user
+	s.requirePass = password
+	s.requireCreds = true
+}
+
+// Remove all fixtures, credentials and recorded commands
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = nil
+	s.defaultFixture = nil
+	s.requireCreds = false
+	s.commands = nil
+}
+
+// Commands received so far, in arrival order
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command{}, s.commands...)
+}
+
+// Most runs of the command at the same time so far, ie to check worker limits
+func (s *Server) MaxActive(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stat, ok := s.stats[name]; ok {
+		return stat.maxActive
+	}
+	return 0
+}
+
+// Stop listening, wait for running sessions and remove the socket
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	if s.socketDir != "" {
+		os.RemoveAll(s.socketDir)
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Read the client chunks up to the command, then run it
+func (s *Server) handleConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	cmd := Command{Env: make(map[string]string)}
+	for {
+		chunkType, payload, err := readChunk(reader)
+		if err != nil {
+			return
+		}
+		switch chunkType {
+		case chunkArgument:
+			cmd.Args = append(cmd.Args, payload)
+		case chunkEnv:
+			if i := strings.Index(payload, "="); i > 0 {
+				cmd.Env[payload[:i]] = payload[i+1:]
+			}
+		case chunkDir:
+			cmd.Dir = payload
+		case chunkCommand:
+			cmd.Name = payload
+			// keep draining heartbeats/stdin so the client never blocks on write
+			go io.Copy(ioutil.Discard, reader)
+			s.run(conn, cmd)
+			return
+		case chunkHeartbeat, chunkStdin, chunkStdinEOF:
+		}
+	}
+}
+
+func (s *Server) run(conn net.Conn, cmd Command) {
+	credErr := readCredentials(&cmd)
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	stat, ok := s.stats[cmd.Name]
+	if !ok {
+		stat = &commandStats{}
+		s.stats[cmd.Name] = stat
+	}
+	stat.runs++
+	stat.active++
+	if stat.active > stat.maxActive {
+		stat.maxActive = stat.active
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		stat.active--
+		s.mu.Unlock()
+	}()
+
+	var f Fixture
+	switch cmd.Name {
+	case JMXQueryCommand:
+		if credErr != nil {
+			f = Fixture{Stderr: "java.io.IOException: " + credErr.Error() + "\n", ExitCode: 1}
+		} else if !s.credentialsValid(cmd) {
+			f = AuthFailure()
+		} else {
+			f = s.lookup(cmd.Arg("-url"))
+		}
+	case StatsCommand:
+		f = Fixture{Output: s.statsReport()}
+	case StopCommand:
+		f = Fixture{}
+	default:
+		f = Fixture{Stderr: "No such command: " + cmd.Name + "\n", ExitCode: ExitNoSuchCommand}
+	}
+
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	if f.Output != "" {
+		if writeChunk(conn, chunkStdout, f.Output) != nil {
+			return
+		}
+	}
+	if f.Stderr != "" {
+		if writeChunk(conn, chunkStderr, f.Stderr) != nil {
+			return
+		}
+	}
+	writeChunk(conn, chunkExit, strconv.Itoa(f.ExitCode)+"\n")
+}
+
+// Credentials from -u/-p, or read and remove the one-time credential file,
+// user on the first line, password on the second
+func readCredentials(cmd *Command) error {
+	if cmd.Name != JMXQueryCommand {
+		return nil
+	}
+	credFile := cmd.Arg("-credfile")
+	if credFile == "" {
+		cmd.User = cmd.Arg("-u")
+		cmd.Password = cmd.Arg("-p")
+		return nil
+	}
+	content, err := ioutil.ReadFile(credFile)
+	if err != nil {
+		return err
+	}
+	os.Remove(credFile)
+	lines := strings.SplitN(string(content), "\n", 3)
+	if len(lines) < 2 {
+		return errors.New("malformed credential file " + credFile)
+	}
+	cmd.User = lines[0]
+	cmd.Password = lines[1]
+	return nil
+}
+
+func (s *Server) credentialsValid(cmd Command) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.requireCreds || (cmd.User == s.requireUser && cmd.Password == s.requirePass)
+}
+
+func (s *Server) lookup(url string) Fixture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.routes {
+		if strings.Contains(url, r.match) {
+			if r.once {
+				s.routes = append(s.routes[:i], s.routes[i+1:]...)
+			}
+			return r.fixture
+		}
+	}
+	if s.defaultFixture != nil {
+		return *s.defaultFixture
+	}
+	return Fixture{
+		Stderr:   "psoftjmxtest: no fixture for " + url + "\n",
+		ExitCode: 1,
+	}
+}
+
+// Same layout as the Nailgun server's ng-stats builtin: <class>: <runs>/<active>
+func (s *Server) statsReport() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.stats))
+	for name := range s.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "NailGun server version %s\n\n", nailgunVersion)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %d/%d\n", name, s.stats[name].runs, s.stats[name].active)
+	}
+	return b.String()
+}
+
+func readChunk(r io.Reader) (byte, string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, "", err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > 1<<24 {
+		return 0, "", errors.New("psoftjmxtest: chunk too large")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, "", err
+	}
+	return header[4], string(payload), nil
+}
+
+func writeChunk(w io.Writer, chunkType byte, payload string) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	header[4] = chunkType
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, payload)
+	return err
+}
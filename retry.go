@@ -0,0 +1,59 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Retry of transient JMX failures, ie a dropped RMI connection
+type RetryPolicy struct {
+	Attempts   int           // total attempts per cycle, 0 or 1 to never retry
+	Backoff    time.Duration // wait before the first retry, doubled on each retry
+	MaxBackoff time.Duration // cap on the wait between retries, 0 for no cap
+	Jitter     float64       // fraction of the wait randomly added or removed, ie 0.2
+}
+
+// Failures that are safe to retry: the target may answer a second later and
+// nothing about the config is wrong.  Refused connections are a down domain,
+// a Nailgun server without JMXQuery or a local file error won't go away.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrConnectReset) ||
+		errors.Is(err, ErrNailgunUnavailable)
+}
+
+// wait before the given retry, 1 for the first retry
+func (r RetryPolicy) delay(retry int) time.Duration {
+	wait := r.Backoff
+	for i := 1; i < retry; i++ {
+		wait *= 2
+		if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+		wait = r.MaxBackoff
+	}
+	if r.Jitter > 0 && wait > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * r.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// Run the JMX command, retrying transient failures. Returns the number of attempts made.
+func (r RetryPolicy) run(domainName string, command func() (string, error)) (string, int, error) {
+	attempt := 1
+	for {
+		response, err := command()
+		if err == nil || attempt >= r.Attempts || !IsRetryable(err) {
+			return response, attempt, err
+		}
+		wait := r.delay(attempt)
+		srvlog.Warn("JMX Request: retrying "+domainName, "attempt", attempt, "wait", wait, "error", err.Error())
+		time.Sleep(wait)
+		attempt++
+	}
+}
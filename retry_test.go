@@ -0,0 +1,88 @@
+package psoftjmx_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UMN-PeopleSoft/psoftjmx"
+	"github.com/UMN-PeopleSoft/psoftjmx/psoftjmxtest"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for kind, want := range map[error]bool{
+		psoftjmx.ErrTimeout:            true,
+		psoftjmx.ErrConnectReset:       true,
+		psoftjmx.ErrNailgunUnavailable: true,
+		psoftjmx.ErrNailgunCommand:     false,
+		psoftjmx.ErrCollectorIO:        false,
+		psoftjmx.ErrAuth:               false,
+		psoftjmx.ErrConnectRefused:     false,
+		psoftjmx.ErrParse:              false,
+		psoftjmx.ErrQueryFailed:        false,
+	} {
+		err := fmt.Errorf("wrapped: %w", &psoftjmx.JMXError{Domain: "HRWEB1", Kind: kind, Err: errors.New("cause")})
+		if got := psoftjmx.IsRetryable(err); got != want {
+			t.Errorf("IsRetryable(%v) = %t, want %t", kind, got, want)
+		}
+	}
+}
+
+func TestGetMetricsRetry(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n"+
+			"HRWEB3 web HR PRD prod PIA 192.0.2.13 8.60 12.2 7001 system secret\n"+
+			"HRWEB4 web HR PRD prod PIA 192.0.2.14 8.60 12.2 7001 system secret\n")
+	config.Retry = psoftjmx.RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond}
+	reset := psoftjmxtest.Fixture{Stderr: "java.rmi.ConnectIOException: Connection reset\n", ExitCode: 1}
+	server.HandleOnce("//192.0.2.11:", reset)
+	server.Handle("//192.0.2.11:", psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12")))
+	server.Handle("//192.0.2.12:", psoftjmxtest.Fixture{Stderr: "No such command\n", ExitCode: psoftjmxtest.ExitNoSuchCommand})
+	server.Handle("//192.0.2.13:", psoftjmxtest.AuthFailure())
+	server.Handle("//192.0.2.14:", reset)
+
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	metrics, err := client.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDomain := metricsByDomain(t, metrics)
+	queries := make(map[string]int)
+	for _, command := range server.Commands() {
+		url := command.Arg("-url")
+		for domainName, record := range byDomain {
+			if strings.Contains(url, "//"+record["host"].(string)+":") {
+				queries[domainName]++
+			}
+		}
+	}
+	tests := []struct {
+		domainName string
+		status     string
+		attempts   int
+	}{
+		{"HRWEB1", "Up", 2},              // stops on the first success
+		{"HRWEB2", "Collector Error", 1}, // 898, Nailgun has no JMXQuery
+		{"HRWEB3", "Config Error", 1},    // 899, bad user/password
+		{"HRWEB4", "Down", 3},            // every attempt used
+	}
+	for _, test := range tests {
+		record := byDomain[test.domainName]
+		if record["status"] != test.status {
+			t.Errorf("%s status = %v, want %s", test.domainName, record["status"], test.status)
+		}
+		if record["attempts"] != test.attempts {
+			t.Errorf("%s attempts = %v, want %d", test.domainName, record["attempts"], test.attempts)
+		}
+		if queries[test.domainName] != test.attempts {
+			t.Errorf("%s: server got %d queries, want %d", test.domainName, queries[test.domainName], test.attempts)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	defaultPollInterval = 60 * time.Second
+	schedulerTick       = time.Second
+	schedulerReload     = 30 * time.Second // how often the inventory is re-read
+)
+
+// Polling interval for targets matching all of the set selectors
+type PollInterval struct {
+	DomainType string // web, app, prc
+	Purpose    string
+	DomainName string // wildcard pattern, ie HR*
+	Interval   time.Duration
+}
+
+func (p PollInterval) matches(domain *PsoftDomain) bool {
+	if p.DomainType != "" && p.DomainType != domain.DomainType {
+		return false
+	}
+	if p.Purpose != "" && p.Purpose != domain.Purpose {
+		return false
+	}
+	if p.DomainName != "" {
+		if matched, _ := filepath.Match(p.DomainName, domain.DomainName); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Keeps polling each target on its own interval and caches the latest results.
+// The scheduler owns the client while running, don't call GetMetrics concurrently.
+// Requests share the client's ConcurrentWorkers limit.
+type Scheduler struct {
+	cli      *PsoftJmxClient
+	mu       sync.Mutex
+	results  map[string]map[string]interface{} // latest metrics by domain name
+	nextPoll map[string]time.Time
+	running  map[string]bool
+	lastLoad time.Time
+	stop     chan struct{} // nil while stopped
+	wg       sync.WaitGroup
+}
+
+// Targets sent on one tick, the cycle stats are recorded once all complete
+type scheduledCycle struct {
+	start     time.Time
+	pending   int // interval groups still running
+	busy      time.Duration
+	responses []JMXResponse
+}
+
+func (cli *PsoftJmxClient) NewScheduler() *Scheduler {
+	return &Scheduler{
+		cli:      cli,
+		results:  make(map[string]map[string]interface{}),
+		nextPoll: make(map[string]time.Time),
+		running:  make(map[string]bool),
+	}
+}
+
+// Polling interval of a target, the first matching PollIntervals entry wins
+func (cli *PsoftJmxClient) pollInterval(domain *PsoftDomain) time.Duration {
+	for _, poll := range cli.Config.PollIntervals {
+		if poll.matches(domain) && poll.Interval > 0 {
+			return poll.Interval
+		}
+	}
+	if cli.Config.DefaultPollInterval > 0 {
+		return cli.Config.DefaultPollInterval
+	}
+	return defaultPollInterval
+}
+
+// Start polling in the background, no-op when already started
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.run(s.stop)
+	srvlog.Info("Scheduler: started")
+}
+
+// Stop polling and wait for running requests to complete, no-op when not started
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	s.wg.Wait()
+	srvlog.Info("Scheduler: stopped")
+}
+
+func (s *Scheduler) run(stop chan struct{}) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	s.poll()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.poll()
+		}
+	}
+}
+
+// Send the requests for all targets that are due and not still running
+func (s *Scheduler) poll() {
+	now := time.Now()
+	if !s.cli.watchingFiles() && now.Sub(s.lastLoad) >= schedulerReload {
+		// a failed load is tried again at the next reload, not on every tick
+		s.lastLoad = now
+		if err := s.cli.LoadTargets(); err != nil {
+			srvlog.Error("Scheduler: unable to load targets, polling the last good list: " + err.Error())
+		}
+	}
+
+	domainList, _, _ := s.cli.snapshot()
+	s.mu.Lock()
+	// targets due now, grouped by interval so slow targets don't hold up faster intervals
+	due := make(map[time.Duration][]*PsoftDomain)
+	current := make(map[string]bool)
+	for _, domain := range domainList {
+		current[domain.DomainName] = true
+		if s.running[domain.DomainName] || now.Before(s.nextPoll[domain.DomainName]) {
+			continue
+		}
+		interval := s.cli.pollInterval(domain)
+		s.running[domain.DomainName] = true
+		s.nextPoll[domain.DomainName] = now.Add(interval)
+		due[interval] = append(due[interval], domain)
+	}
+	// forget targets removed from the inventory
+	for domainName := range s.nextPoll {
+		if !current[domainName] {
+			delete(s.nextPoll, domainName)
+			delete(s.results, domainName)
+		}
+	}
+	s.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	if !s.cli.watchingFiles() {
+		s.cli.loadBlackoutsAndExclusions()
+	}
+	cycle := &scheduledCycle{start: now, pending: len(due)}
+	for _, domains := range due {
+		requests, err := s.cli.buildRequests(domains)
+		if err != nil {
+			srvlog.Error("Scheduler: unable to build requests: " + err.Error())
+			s.finish(cycle, domains, nil, 0)
+			continue
+		}
+		s.wg.Add(1)
+		go func(domains []*PsoftDomain) {
+			defer s.wg.Done()
+			responses, busy := s.cli.runPool(requests)
+			s.finish(cycle, domains, responses, busy)
+		}(domains)
+	}
+}
+
+// Cache the results of an interval group, and record the cycle stats of the
+// tick once its last group completes
+func (s *Scheduler) finish(cycle *scheduledCycle, due []*PsoftDomain, responses []JMXResponse, busy time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, domain := range due {
+		delete(s.running, domain.DomainName)
+	}
+	cycle.pending--
+	cycle.busy += busy
+	cycle.responses = append(cycle.responses, responses...)
+	if cycle.pending == 0 {
+		s.cli.recordCycle(newCycleStats(cycle.start, s.cli.Config.ConcurrentWorkers, cycle.busy, cycle.responses))
+	}
+	for _, result := range responseMetrics(responses) {
+		domainName, _ := result["domain_name"].(string)
+		if _, ok := s.nextPoll[domainName]; ok {
+			s.results[domainName] = result
+		}
+	}
+}
+
+// Latest metrics of every target, plus the collector record
+func (s *Scheduler) Results() []map[string]interface{} {
+	s.mu.Lock()
+	domainNames := make([]string, 0, len(s.results))
+	for domainName := range s.results {
+		domainNames = append(domainNames, domainName)
+	}
+	sort.Strings(domainNames)
+	results := make([]map[string]interface{}, 0, len(domainNames)+1)
+	for _, domainName := range domainNames {
+		results = append(results, s.results[domainName])
+	}
+	s.mu.Unlock()
+	return append(results, s.cli.collectorMetrics())
+}
+
+// Latest metrics of a single target
+func (s *Scheduler) Result(domainName string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[domainName]
+	return result, ok
+}
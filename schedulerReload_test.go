@@ -0,0 +1,28 @@
+package psoftjmx
+
+import "testing"
+
+func TestSchedulerReloadAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	inventory := writeTestFile(t, dir, "inventory.txt", "HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 notaport system secret\n")
+	cli := &PsoftJmxClient{Config: &JMXConfig{PathInventoryFile: inventory}}
+	s := cli.NewScheduler()
+	s.poll()
+	if s.lastLoad.IsZero() {
+		t.Fatal("a failed load should wait for the next reload")
+	}
+
+	// fixed, but not picked up before schedulerReload
+	writeTestFile(t, dir, "inventory.txt", "HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	s.poll()
+	if domains, _, _ := cli.snapshot(); len(domains) != 0 {
+		t.Fatalf("got %d targets, want the reload to wait", len(domains))
+	}
+	// loaded, but not due so nothing is sent
+	s.lastLoad = s.lastLoad.Add(-schedulerReload)
+	s.nextPoll["HRWEB1"] = s.lastLoad.Add(2 * schedulerReload)
+	s.poll()
+	if domains, _, _ := cli.snapshot(); len(domains) != 1 {
+		t.Errorf("got %d targets after the reload interval, want 1", len(domains))
+	}
+}
@@ -0,0 +1,55 @@
+package psoftjmx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UMN-PeopleSoft/psoftjmx"
+	"github.com/UMN-PeopleSoft/psoftjmx/psoftjmxtest"
+)
+
+func TestSchedulerSharesWorkers(t *testing.T) {
+	server, config := newTestClientConfig(t,
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n"+
+			"HRWEB3 web HR PRD prod PIA 192.0.2.13 8.60 12.2 7001 system secret\n"+
+			"CSWEB1 web CS TST test PIA 192.0.2.21 8.60 12.2 7001 system secret\n"+
+			"CSWEB2 web CS TST test PIA 192.0.2.22 8.60 12.2 7001 system secret\n"+
+			"CSWEB3 web CS TST test PIA 192.0.2.23 8.60 12.2 7001 system secret\n")
+	config.ConcurrentWorkers = 2
+	// two interval groups due on the first tick
+	config.PollIntervals = []psoftjmx.PollInterval{
+		{Purpose: "prod", Interval: time.Hour},
+		{Purpose: "test", Interval: 2 * time.Hour},
+	}
+	slow := psoftjmxtest.Respond(psoftjmxtest.Result("com.bea:ServerRuntime=PIA,Name=PIA", "OpenSessionsCurrentCount", "12"))
+	slow.Delay = 100 * time.Millisecond
+	server.HandleDefault(slow)
+	client, err := psoftjmx.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	scheduler := client.NewScheduler()
+	scheduler.Stop() // not started yet
+	scheduler.Start()
+	scheduler.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.LastCycle().Targets != 6 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	scheduler.Stop()
+	scheduler.Stop()
+
+	cycle := client.LastCycle()
+	if cycle.Targets != 6 || cycle.Ok != 6 {
+		t.Errorf("cycle stats = %+v, want both interval groups in one cycle of 6 ok targets", cycle)
+	}
+	if got := len(metricsByDomain(t, scheduler.Results())); got != 6 {
+		t.Errorf("got %d cached results, want 6", got)
+	}
+	if active := server.MaxActive(psoftjmxtest.JMXQueryCommand); active > config.ConcurrentWorkers {
+		t.Errorf("%d JMXQuery ran at once, want at most ConcurrentWorkers %d", active, config.ConcurrentWorkers)
+	}
+}
@@ -0,0 +1,201 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sqlInventorySource  = "sql inventory" // file part of the row errors, ie "sql inventory:3: ..."
+	sqlInventoryTimeout = 30 * time.Second
+)
+
+// Last good SQL inventory
+type sqlInventoryCache struct {
+	domains []*PsoftDomain
+	queried time.Time // last query, good or not
+}
+
+// Read targets from a SQL query, one target per row.  Result columns are
+// matched to the inventory columns by name, ignoring case, unless mapped
+// with columnMap.  The driver must be registered by the application, ie by
+// importing github.com/mattn/go-sqlite3.  Rows are validated like the
+// inventory file, every problem is reported with its row number.
+func ReadSQLInventory(driver string, dsn string, query string, columnMap map[string]string) ([]*PsoftDomain, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), sqlInventoryTimeout)
+	defer cancel()
+	srvlog.Debug("Running inventory query with driver " + driver)
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var lineErrors []InventoryLineError
+	addError := func(row int, format string, args ...interface{}) {
+		lineErrors = append(lineErrors, InventoryLineError{File: sqlInventorySource, Line: row, Err: fmt.Sprintf(format, args...)})
+	}
+	columns := make([]inventoryColumn, len(names))
+	for i, name := range names {
+		if mapped, ok := columnMap[name]; ok {
+			name = mapped
+		}
+		column, ok := findInventoryColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("Inventory query column %q is not an inventory column", names[i])
+		}
+		columns[i] = column
+	}
+
+	domainRows := make(map[string]int)
+	domainList := []*PsoftDomain{}
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	rowNum := 0
+	for rows.Next() {
+		rowNum++
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		domain := &PsoftDomain{source: fmt.Sprintf("%s:%d", sqlInventorySource, rowNum)}
+		for i, value := range values {
+			if !value.Valid {
+				continue
+			}
+			if err := columns[i].set(domain, strings.TrimSpace(value.String)); err != nil {
+				addError(rowNum, "column %s: %s", columns[i].name, err)
+			}
+		}
+		if domain.DomainName == "" {
+			addError(rowNum, "missing DomainName")
+			continue
+		}
+		for _, problem := range validateDomain(domain) {
+			addError(rowNum, "%s", problem)
+		}
+		if firstRow, ok := domainRows[domain.DomainName]; ok {
+			addError(rowNum, "duplicate DomainName %s, first defined on row %d", domain.DomainName, firstRow)
+			continue
+		}
+		domainRows[domain.DomainName] = rowNum
+		domainList = append(domainList, domain)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(lineErrors) > 0 {
+		return nil, &InventoryError{Errors: lineErrors}
+	}
+	return domainList, nil
+}
+
+// Targets of the SQL inventory, queried at most every SQLInventoryInterval.
+// When the query fails the last good result is used, from memory or else
+// from SQLInventoryCacheFile, and the query is tried again next interval.
+func (cli *PsoftJmxClient) sqlInventory() ([]*PsoftDomain, error) {
+	now := time.Now()
+	cli.statsMu.Lock()
+	cache := cli.sqlCache
+	cli.statsMu.Unlock()
+	if cache != nil && now.Sub(cache.queried) < cli.Config.SQLInventoryInterval {
+		return copyDomains(cache.domains), nil
+	}
+
+	domains, err := ReadSQLInventory(cli.Config.SQLInventoryDriver, cli.Config.SQLInventoryDSN,
+		cli.Config.SQLInventoryQuery, cli.Config.SQLInventoryColumns)
+	if err != nil {
+		if cache == nil {
+			cachedDomains, cacheErr := readSQLInventoryCache(cli.Config.SQLInventoryCacheFile)
+			if cacheErr != nil {
+				return nil, fmt.Errorf("Inventory query failed: %s", err)
+			}
+			cache = &sqlInventoryCache{domains: cachedDomains}
+			srvlog.Error("Inventory query failed, using the cache file: "+err.Error(), "file", cli.Config.SQLInventoryCacheFile)
+		} else {
+			srvlog.Error("Inventory query failed, keeping the last good result: " + err.Error())
+		}
+		domains = cache.domains
+	} else {
+		srvlog.Debug("Inventory query returned " + fmt.Sprint(len(domains)) + " targets")
+		if err := writeSQLInventoryCache(cli.Config.SQLInventoryCacheFile, domains); err != nil {
+			srvlog.Error("Unable to write the inventory cache file: " + err.Error())
+		}
+	}
+	cli.statsMu.Lock()
+	cli.sqlCache = &sqlInventoryCache{domains: domains, queried: now}
+	cli.statsMu.Unlock()
+	return copyDomains(domains), nil
+}
+
+// Whether the SQL inventory is due for a query, used when the inventory is
+// only reloaded on file changes
+func (cli *PsoftJmxClient) sqlInventoryDue(now time.Time) bool {
+	if cli.Config.SQLInventoryQuery == "" {
+		return false
+	}
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	return cli.sqlCache == nil || now.Sub(cli.sqlCache.queried) >= cli.Config.SQLInventoryInterval
+}
+
+// the targets are renamed and resolved after loading, keep the cache untouched
+func copyDomains(domains []*PsoftDomain) []*PsoftDomain {
+	copies := make([]*PsoftDomain, len(domains))
+	for i, domain := range domains {
+		domainCopy := *domain
+		copies[i] = &domainCopy
+	}
+	return copies
+}
+
+func readSQLInventoryCache(path string) ([]*PsoftDomain, error) {
+	if path == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var domains []*PsoftDomain
+	if err := json.Unmarshal(data, &domains); err != nil {
+		return nil, fmt.Errorf("Invalid inventory cache file %s: %s", path, err)
+	}
+	for i, domain := range domains {
+		domain.source = fmt.Sprintf("%s:%d", path, i+1)
+	}
+	return domains, nil
+}
+
+// Replace the cache file in one step, it holds JMX passwords so only the
+// collector user can read it
+func writeSQLInventoryCache(path string, domains []*PsoftDomain) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(domains, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,121 @@
+package psoftjmx
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testSQLQuery = "select name as DomainName, type as DomainType, host, port as JMXPort, user as JMXUser, password as jmxpassword, tools as ToolsVer, labels from targets order by id"
+
+// SQLite database with a targets table holding the rows
+func writeTestDatabase(t *testing.T, dir string, rows ...[]interface{}) string {
+	t.Helper()
+	path := filepath.Join(dir, "inventory.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table targets (id integer, name text, type text, host text, port integer, user text, password text, tools text, labels text)"); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		if _, err := db.Exec("insert into targets values (?, ?, ?, ?, ?, ?, ?, ?, ?)", append([]interface{}{i + 1}, row...)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestReadSQLInventory(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(),
+		[]interface{}{"HRWEB1", "web", "192.0.2.11", 7001, "system", "secret", "8.60", "team=hcm"},
+		[]interface{}{"HRAPP1", "app", "192.0.2.12", 9000, "system", "secret", nil, nil})
+	domains, err := ReadSQLInventory("sqlite3", path, testSQLQuery, map[string]string{"host": "HostName"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("got %d targets, want 2", len(domains))
+	}
+	web, app := domains[0], domains[1]
+	if web.DomainName != "HRWEB1" || web.HostName != "192.0.2.11" || web.JMXPort != "7001" || web.JMXPassword != "secret" || web.ToolsVer != "8.60" {
+		t.Errorf("HRWEB1 columns: %#v", web)
+	}
+	if web.Labels["team"] != "hcm" {
+		t.Errorf("HRWEB1 labels = %v, want team=hcm", web.Labels)
+	}
+	if web.source != "sql inventory:1" {
+		t.Errorf("HRWEB1 source = %s, want sql inventory:1", web.source)
+	}
+	// NULL columns are left unset
+	if app.ToolsVer != "" || len(app.Labels) != 0 {
+		t.Errorf("HRAPP1 NULL columns: ToolsVer %q, Labels %v", app.ToolsVer, app.Labels)
+	}
+
+	if _, err := ReadSQLInventory("sqlite3", path, "select name as DomainName, id from targets", nil); err == nil {
+		t.Error("want an error for a column that isn't an inventory column")
+	}
+}
+
+func TestReadSQLInventoryRowErrors(t *testing.T) {
+	path := writeTestDatabase(t, t.TempDir(),
+		[]interface{}{"HRWEB1", "web", "192.0.2.11", 7001, "system", "secret", nil, nil},
+		[]interface{}{nil, "web", "192.0.2.12", 7001, "system", "secret", nil, nil},
+		[]interface{}{"HRWEB3", "web", "192.0.2.13", 0, "system", "secret", nil, nil},
+		[]interface{}{"HRWEB4", "web", nil, 7001, "system", "secret", nil, nil},
+		[]interface{}{"HRWEB1", "web", "192.0.2.15", 7001, "system", "secret", nil, nil})
+	_, err := ReadSQLInventory("sqlite3", path, testSQLQuery, map[string]string{"host": "HostName"})
+	inventoryErr, ok := err.(*InventoryError)
+	if !ok {
+		t.Fatalf("got %v, want an InventoryError", err)
+	}
+	rows := []int{}
+	for _, lineErr := range inventoryErr.Errors {
+		if lineErr.File != sqlInventorySource {
+			t.Errorf("error %v isn't from the sql inventory", lineErr)
+		}
+		rows = append(rows, lineErr.Line)
+	}
+	// missing name, invalid port, missing host, duplicate
+	if len(rows) != 4 || rows[0] != 2 || rows[1] != 3 || rows[2] != 4 || rows[3] != 5 {
+		t.Errorf("got errors on rows %v, want 2, 3, 4 and 5: %v", rows, err)
+	}
+}
+
+func TestSQLInventoryCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	config := &JMXConfig{
+		SQLInventoryDriver:    "sqlite3",
+		SQLInventoryDSN:       writeTestDatabase(t, dir, []interface{}{"HRWEB1", "web", "192.0.2.11", 7001, "system", "secret", nil, nil}),
+		SQLInventoryQuery:     testSQLQuery,
+		SQLInventoryColumns:   map[string]string{"host": "HostName"},
+		SQLInventoryCacheFile: filepath.Join(dir, "inventory-cache.json"),
+	}
+	cli := &PsoftJmxClient{Config: config}
+	if domains, err := cli.sqlInventory(); err != nil || len(domains) != 1 {
+		t.Fatalf("got %d targets and %v, want 1", len(domains), err)
+	}
+
+	// the query fails: the same client keeps its last result, a new one reads the cache file
+	config.SQLInventoryQuery = "select * from missing"
+	if domains, err := cli.sqlInventory(); err != nil || len(domains) != 1 {
+		t.Errorf("after a failed query: got %d targets and %v, want the last good 1", len(domains), err)
+	}
+	restarted := &PsoftJmxClient{Config: config}
+	domains, err := restarted.sqlInventory()
+	if err != nil || len(domains) != 1 {
+		t.Fatalf("from the cache file: got %d targets and %v, want 1", len(domains), err)
+	}
+	if domains[0].DomainName != "HRWEB1" || domains[0].JMXPassword != "secret" || domains[0].source != config.SQLInventoryCacheFile+":1" {
+		t.Errorf("cached target: %#v", domains[0])
+	}
+
+	config.SQLInventoryCacheFile = filepath.Join(dir, "missing.json")
+	if _, err := (&PsoftJmxClient{Config: config}).sqlInventory(); err == nil {
+		t.Error("want an error without a result or a cache file")
+	}
+}
@@ -0,0 +1,269 @@
+// Poeplesoft Metric Capture via JMX
+
+package psoftjmx
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// wait for an editor to finish writing before reloading
+	fileReloadDelay = 500 * time.Millisecond
+	// admin server lists and the SQL inventory are checked this many times per interval
+	refreshChecks time.Duration = 10
+)
+
+// Reloads the inventory, blackout and exclusion files when they change
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+	reloads map[string]func() // by cleaned absolute file path
+	globs   map[string]func() // by absolute glob pattern, ie inventory.d/*.txt
+	mu      sync.Mutex
+	timers  map[string]*time.Timer
+	ticker  *time.Ticker // checks whether the admin server lists or SQL inventory need a refresh
+	refresh func()
+	done    chan struct{}
+}
+
+// Watch the inventory, credentials, blackout and exclusion files and reload
+// each one only when it changes, instead of re-reading them on every
+// GetMetrics call.  A changed file is validated before it replaces the last
+// good version.
+func (cli *PsoftJmxClient) WatchFiles() error {
+	if cli.watchingFiles() {
+		return nil
+	}
+	err := cli.LoadTargets()
+	if err != nil {
+		return err
+	}
+	cli.loadBlackoutsAndExclusions()
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	fw := &fileWatcher{
+		watcher: fsWatcher,
+		reloads: make(map[string]func()),
+		globs:   make(map[string]func()),
+		timers:  make(map[string]*time.Timer),
+		ticker:  time.NewTicker(cli.refreshCheckInterval()),
+		refresh: cli.refreshDynamicTargets,
+		done:    make(chan struct{}),
+	}
+	files := map[string]func(){
+		cli.Config.PathBlackoutFile:     cli.reloadBlackouts,
+		cli.Config.PathBlackoutCalendar: cli.reloadBlackouts,
+		cli.Config.PathExclusionFile:    cli.reloadExclusions,
+	}
+	for _, pattern := range cli.Config.inventoryPatterns() {
+		files[pattern] = cli.reloadInventory
+	}
+	files[cli.Config.PathCredentialsFile] = cli.reloadInventory
+	dirs := make(map[string]bool)
+	for path, reload := range files {
+		if path == "" {
+			continue
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			fsWatcher.Close()
+			return err
+		}
+		if info, err := os.Stat(absPath); err == nil && info.IsDir() {
+			absPath = filepath.Join(absPath, "*")
+		}
+		if isGlobPattern(absPath) {
+			if isGlobPattern(filepath.Dir(absPath)) {
+				srvlog.Warn("Not watching " + path + ", only the file name part of a pattern can be a glob")
+				continue
+			}
+			fw.globs[absPath] = reload
+		} else {
+			fw.reloads[absPath] = reload
+		}
+		// watch the directory, editors and config tools replace files by renaming
+		dirs[filepath.Dir(absPath)] = true
+	}
+	for dir := range dirs {
+		if err := fsWatcher.Add(dir); err != nil {
+			fsWatcher.Close()
+			return err
+		}
+		srvlog.Debug("Watching directory " + dir)
+	}
+	go fw.run()
+
+	cli.statsMu.Lock()
+	cli.watcher = fw
+	cli.statsMu.Unlock()
+	srvlog.Info("Watching inventory, blackout and exclusion files for changes")
+	return nil
+}
+
+// Stop watching files, GetMetrics goes back to reloading them on every call
+func (cli *PsoftJmxClient) StopWatching() {
+	cli.statsMu.Lock()
+	fw := cli.watcher
+	cli.watcher = nil
+	cli.statsMu.Unlock()
+	if fw == nil {
+		return
+	}
+	fw.watcher.Close()
+	fw.ticker.Stop()
+	<-fw.done
+	fw.mu.Lock()
+	for _, timer := range fw.timers {
+		timer.Stop()
+	}
+	fw.mu.Unlock()
+}
+
+func (cli *PsoftJmxClient) watchingFiles() bool {
+	cli.statsMu.Lock()
+	defer cli.statsMu.Unlock()
+	return cli.watcher != nil
+}
+
+func (fw *fileWatcher) run() {
+	defer close(fw.done)
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			path := filepath.Clean(event.Name)
+			if reload, ok := fw.reloads[path]; ok {
+				srvlog.Debug("File changed: " + event.String())
+				fw.schedule(path, reload)
+				continue
+			}
+			for pattern, reload := range fw.globs {
+				if matched, _ := filepath.Match(pattern, path); matched {
+					srvlog.Debug("File changed: " + event.String())
+					fw.schedule(pattern, reload)
+					break
+				}
+			}
+		case <-fw.ticker.C:
+			fw.refresh()
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			srvlog.Error("File watch error: " + err.Error())
+		}
+	}
+}
+
+// reload once the file or pattern has been quiet for fileReloadDelay
+func (fw *fileWatcher) schedule(path string, reload func()) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if timer, ok := fw.timers[path]; ok {
+		timer.Reset(fileReloadDelay)
+		return
+	}
+	fw.timers[path] = time.AfterFunc(fileReloadDelay, reload)
+}
+
+// Reload timers of different files and the refresh ticker can fire together,
+// the loads run one at a time and each diffs against the list it replaces
+func (cli *PsoftJmxClient) reloadInventory() {
+	cli.targetsMu.Lock()
+	defer cli.targetsMu.Unlock()
+	oldList, _, _ := cli.snapshot()
+	newList, err := cli.readTargets()
+	if err != nil {
+		srvlog.Error("Inventory reload rejected, keeping the last good version: " + err.Error())
+		return
+	}
+	// most likely caught mid-write, an intentionally empty inventory is picked up on restart
+	if len(newList) == 0 && len(oldList) > 0 {
+		srvlog.Warn("Inventory reload found no targets, keeping the last good version")
+		return
+	}
+	cli.listMu.Lock()
+	cli.DomainList = newList
+	cli.listMu.Unlock()
+	cli.domainBreakers().forget(newList)
+
+	added, removed, changed := diffDomains(oldList, newList)
+	srvlog.Info("Inventory reloaded", "targets", len(newList),
+		"added", strings.Join(added, ","), "removed", strings.Join(removed, ","), "changed", strings.Join(changed, ","))
+}
+
+// the inventory files are only re-read on changes, but admin server lists
+// and the SQL inventory expire
+func (cli *PsoftJmxClient) refreshDynamicTargets() {
+	now := time.Now()
+	if cli.adminExpansionDue(now) || cli.sqlInventoryDue(now) {
+		cli.reloadInventory()
+	}
+}
+
+func (cli *PsoftJmxClient) refreshCheckInterval() time.Duration {
+	interval := cli.Config.AdminExpansionInterval
+	if cli.Config.SQLInventoryQuery != "" && cli.Config.SQLInventoryInterval < interval {
+		interval = cli.Config.SQLInventoryInterval
+	}
+	return interval / refreshChecks
+}
+
+func (cli *PsoftJmxClient) reloadBlackouts() {
+	if err := cli.LoadBlackouts(); err != nil {
+		srvlog.Error("Blackout reload rejected, keeping the last good version: " + err.Error())
+		return
+	}
+	_, blackouts, _ := cli.snapshot()
+	srvlog.Info("Blackouts reloaded", "blackouts", len(blackouts))
+}
+
+func (cli *PsoftJmxClient) reloadExclusions() {
+	if err := cli.LoadExclusions(); err != nil {
+		srvlog.Error("Exclusion reload rejected, keeping the last good version: " + err.Error())
+		return
+	}
+	_, _, excludes := cli.snapshot()
+	srvlog.Info("Exclusions reloaded", "exclusions", len(excludes))
+}
+
+// Domain names added, removed and changed between two inventory loads
+func diffDomains(oldList []*PsoftDomain, newList []*PsoftDomain) (added []string, removed []string, changed []string) {
+	oldDomains := make(map[string]*PsoftDomain)
+	for _, domain := range oldList {
+		oldDomains[domain.DomainName] = domain
+	}
+	newDomains := make(map[string]bool)
+	for _, domain := range newList {
+		newDomains[domain.DomainName] = true
+		oldDomain, ok := oldDomains[domain.DomainName]
+		if !ok {
+			added = append(added, domain.DomainName)
+		} else if !sameDomain(oldDomain, domain) {
+			changed = append(changed, domain.DomainName)
+		}
+	}
+	for _, domain := range oldList {
+		if !newDomains[domain.DomainName] {
+			removed = append(removed, domain.DomainName)
+		}
+	}
+	return added, removed, changed
+}
+
+// same target settings, wherever in the inventory files it is defined
+func sameDomain(a *PsoftDomain, b *PsoftDomain) bool {
+	x, y := *a, *b
+	x.source, y.source = "", ""
+	return reflect.DeepEqual(x, y)
+}
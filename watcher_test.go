@@ -0,0 +1,67 @@
+package psoftjmx
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDiffDomains(t *testing.T) {
+	oldList := []*PsoftDomain{
+		{DomainName: "HRWEB1", HostName: "192.0.2.11", JMXPort: "7001", source: "inventory.txt:1"},
+		{DomainName: "HRWEB2", HostName: "192.0.2.12", JMXPort: "7001", source: "inventory.txt:2"},
+		{DomainName: "HRWEB3", HostName: "192.0.2.13", JMXPort: "7001", source: "inventory.txt:3"},
+	}
+	newList := []*PsoftDomain{
+		// moved to another line, same settings
+		{DomainName: "HRWEB1", HostName: "192.0.2.11", JMXPort: "7001", source: "inventory.txt:2"},
+		{DomainName: "HRWEB2", HostName: "192.0.2.12", JMXPort: "7011", source: "inventory.txt:1"},
+		{DomainName: "HRWEB4", HostName: "192.0.2.14", JMXPort: "7001", source: "inventory.txt:3"},
+	}
+	added, removed, changed := diffDomains(oldList, newList)
+	if !reflect.DeepEqual(added, []string{"HRWEB4"}) {
+		t.Errorf("added = %v, want HRWEB4", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"HRWEB3"}) {
+		t.Errorf("removed = %v, want HRWEB3", removed)
+	}
+	if !reflect.DeepEqual(changed, []string{"HRWEB2"}) {
+		t.Errorf("changed = %v, want HRWEB2", changed)
+	}
+}
+
+func TestReloadInventory(t *testing.T) {
+	dir := t.TempDir()
+	inventory := writeTestFile(t, dir, "inventory.txt",
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n")
+	cli := &PsoftJmxClient{Config: &JMXConfig{PathInventoryFile: inventory}}
+	if err := cli.LoadTargets(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, dir, "inventory.txt",
+		"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 7001 system secret\n"+
+			"HRWEB2 web HR PRD prod PIA 192.0.2.12 8.60 12.2 7001 system secret\n")
+	// timers and the refresh ticker firing together
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cli.reloadInventory()
+		}()
+	}
+	wg.Wait()
+	if domains, _, _ := cli.snapshot(); len(domains) != 2 {
+		t.Fatalf("got %d targets after the reload, want 2", len(domains))
+	}
+
+	// an invalid or empty file keeps the last good list
+	for _, content := range []string{"HRWEB1 web HR PRD prod PIA 192.0.2.11 8.60 12.2 notaport system secret\n", ""} {
+		writeTestFile(t, dir, "inventory.txt", content)
+		cli.reloadInventory()
+		if domains, _, _ := cli.snapshot(); len(domains) != 2 {
+			t.Errorf("inventory %q: got %d targets, want the last good 2", content, len(domains))
+		}
+	}
+}